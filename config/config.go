@@ -0,0 +1,317 @@
+// Package config loads the application settings. Defaults are overridden by
+// an optional JSON file, which is in turn overridden by environment
+// variables, so secrets never have to live in the file.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+type Config struct {
+	// ListenAddr is the address the HTTP server binds to.
+	ListenAddr string `json:"listen_addr"`
+	// BaseURL is the public URL of the site, used for links in emails.
+	BaseURL string `json:"base_url"`
+	// DatabaseDriver is "postgres" or "sqlite".
+	DatabaseDriver string `json:"database_driver"`
+	// DatabaseURL is the Postgres connection string, or the path of the
+	// SQLite database file.
+	DatabaseURL string `json:"database_url"`
+	// SessionKeys sign the session cookie. The first key signs new cookies;
+	// the others are still accepted so keys can be rotated.
+	SessionKeys []string `json:"session_keys"`
+	// UploadDir holds uploaded book images. It is served under /asset/, so
+	// it must be a folder below the working directory, and the mail folder,
+	// the SQLite database and the config file must live outside it.
+	UploadDir string `json:"upload_dir"`
+	// HoldHours is how long a copy is kept for the member at the front of
+	// a waitlist before it passes to the next one.
+	HoldHours int       `json:"hold_hours"`
+	Loans     Loans     `json:"loans"`
+	Mail      Mail      `json:"mail"`
+	PageSize  PageSizes `json:"page_size"`
+}
+
+// Loans is the lending policy. MaxLoanDays, MaxItemsOut, MaxRenewals and
+// FinePerDay are defaults; circulation policies override them per category
+// and role.
+type Loans struct {
+	// LoanDays is the length of the booking suggested on a book's page,
+	// cut to MaxLoanDays where a policy sets it.
+	LoanDays int `json:"loan_days"`
+	// RenewalDays is how far a renewal moves the end of a booking.
+	RenewalDays int `json:"renewal_days"`
+	// MaxLoanDays is the longest a booking can last; 0 means no limit.
+	MaxLoanDays int `json:"max_loan_days"`
+	// MaxItemsOut is how many reserved and checked out bookings a member
+	// can have at once; 0 means no limit.
+	MaxItemsOut int `json:"max_items_out"`
+	// MaxRenewals is how often a booking can be renewed; 0 turns renewals
+	// off.
+	MaxRenewals int `json:"max_renewals"`
+	// FinePerDay is charged, in cents, for each started day a loan is
+	// overdue.
+	FinePerDay int `json:"fine_per_day"`
+	// MaxBalance is the most a member can owe, in cents, and still make
+	// new bookings.
+	MaxBalance int `json:"max_balance"`
+}
+
+type Mail struct {
+	// Backend is "file", "smtp" or "memory".
+	Backend      string `json:"backend"`
+	Dir          string `json:"dir"`
+	From         string `json:"from"`
+	SMTPHost     string `json:"smtp_host"`
+	SMTPPort     int    `json:"smtp_port"`
+	SMTPUsername string `json:"smtp_username"`
+	SMTPPassword string `json:"smtp_password"`
+	// RetentionDays is how long sent and failed mails are kept in the
+	// outbox before they are deleted.
+	RetentionDays int `json:"retention_days"`
+}
+
+// PageSizes are the number of rows shown per page on each list.
+type PageSizes struct {
+	Books int `json:"books"`
+	// BooksMax caps the page size readers can pick on the book list.
+	BooksMax    int `json:"books_max"`
+	Categories  int `json:"categories"`
+	MyBookings  int `json:"my_bookings"`
+	AllBookings int `json:"all_bookings"`
+	Ledger      int `json:"ledger"`
+	API         int `json:"api"`
+	APIMax      int `json:"api_max"`
+}
+
+// Default returns the settings used when nothing else is configured. It has
+// no session key on purpose: one must always be provided.
+func Default() Config {
+	return Config{
+		ListenAddr:     "127.0.0.1:3000",
+		BaseURL:        "http://localhost:3000",
+		DatabaseDriver: "postgres",
+		DatabaseURL:    "user=postgres dbname=library sslmode=disable",
+		UploadDir:      "assets/image",
+		HoldHours:      48,
+		Loans: Loans{
+			LoanDays:    7,
+			RenewalDays: 7,
+			MaxRenewals: 2,
+			MaxBalance:  1000,
+		},
+		Mail: Mail{
+			Backend:       "file",
+			Dir:           "mail",
+			From:          "library@localhost",
+			SMTPPort:      587,
+			RetentionDays: 30,
+		},
+		PageSize: PageSizes{
+			Books:       3,
+			BooksMax:    50,
+			Categories:  3,
+			MyBookings:  4,
+			AllBookings: 10,
+			Ledger:      10,
+			API:         20,
+			APIMax:      100,
+		},
+	}
+}
+
+// Load reads the config file at path, if path is not empty, then applies
+// environment variables and validates the result.
+func Load(path string) (Config, error) {
+	cfg := Default()
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, fmt.Errorf("config: %w", err)
+		}
+		if err := json.Unmarshal(b, &cfg); err != nil {
+			return cfg, fmt.Errorf("config: %s: %w", path, err)
+		}
+	}
+	if err := cfg.applyEnv(); err != nil {
+		return cfg, fmt.Errorf("config: %w", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return cfg, fmt.Errorf("config: %w", err)
+	}
+	if path != "" && cfg.served(path) {
+		return cfg, fmt.Errorf("config: %s must not be inside upload_dir, which is served to everyone", path)
+	}
+	cfg.BaseURL = strings.TrimRight(cfg.BaseURL, "/")
+	return cfg, nil
+}
+
+func (c *Config) applyEnv() error {
+	str := func(name string, dst *string) {
+		if v, ok := os.LookupEnv(name); ok {
+			*dst = v
+		}
+	}
+	var err error
+	num := func(name string, dst *int) {
+		v, ok := os.LookupEnv(name)
+		if !ok || err != nil {
+			return
+		}
+		n, convErr := strconv.Atoi(v)
+		if convErr != nil {
+			err = fmt.Errorf("%s: %q is not a number", name, v)
+			return
+		}
+		*dst = n
+	}
+
+	str("LISTEN_ADDR", &c.ListenAddr)
+	str("BASE_URL", &c.BaseURL)
+	str("DATABASE_DRIVER", &c.DatabaseDriver)
+	str("DATABASE_URL", &c.DatabaseURL)
+	if v, ok := os.LookupEnv("SESSION_KEYS"); ok {
+		c.SessionKeys = strings.Split(v, ",")
+	}
+	str("UPLOAD_DIR", &c.UploadDir)
+	num("HOLD_HOURS", &c.HoldHours)
+	num("LOAN_DAYS", &c.Loans.LoanDays)
+	num("RENEWAL_DAYS", &c.Loans.RenewalDays)
+	num("MAX_LOAN_DAYS", &c.Loans.MaxLoanDays)
+	num("MAX_ITEMS_OUT", &c.Loans.MaxItemsOut)
+	num("MAX_RENEWALS", &c.Loans.MaxRenewals)
+	num("FINE_PER_DAY", &c.Loans.FinePerDay)
+	num("MAX_BALANCE", &c.Loans.MaxBalance)
+
+	str("MAIL_BACKEND", &c.Mail.Backend)
+	str("MAIL_DIR", &c.Mail.Dir)
+	str("MAIL_FROM", &c.Mail.From)
+	str("SMTP_HOST", &c.Mail.SMTPHost)
+	num("SMTP_PORT", &c.Mail.SMTPPort)
+	str("SMTP_USERNAME", &c.Mail.SMTPUsername)
+	str("SMTP_PASSWORD", &c.Mail.SMTPPassword)
+	num("MAIL_RETENTION_DAYS", &c.Mail.RetentionDays)
+
+	num("PAGE_SIZE_BOOKS", &c.PageSize.Books)
+	num("PAGE_SIZE_BOOKS_MAX", &c.PageSize.BooksMax)
+	num("PAGE_SIZE_CATEGORIES", &c.PageSize.Categories)
+	num("PAGE_SIZE_MY_BOOKINGS", &c.PageSize.MyBookings)
+	num("PAGE_SIZE_ALL_BOOKINGS", &c.PageSize.AllBookings)
+	num("PAGE_SIZE_LEDGER", &c.PageSize.Ledger)
+	num("PAGE_SIZE_API", &c.PageSize.API)
+	num("PAGE_SIZE_API_MAX", &c.PageSize.APIMax)
+	return err
+}
+
+func (c *Config) Validate() error {
+	return validation.ValidateStruct(c,
+		validation.Field(&c.ListenAddr, validation.Required),
+		validation.Field(&c.BaseURL, validation.Required, validation.By(absoluteURL)),
+		validation.Field(&c.DatabaseDriver, validation.Required, validation.In("postgres", "sqlite")),
+		validation.Field(&c.DatabaseURL, validation.Required, validation.By(c.notServedDatabase)),
+		validation.Field(&c.SessionKeys,
+			validation.Required.Error("at least one session key is required (SESSION_KEYS)"),
+			validation.Each(validation.Length(32, 0).Error("session keys must be at least 32 characters"))),
+		validation.Field(&c.UploadDir, validation.Required, validation.By(subfolder)),
+		validation.Field(&c.HoldHours, validation.Required, validation.Min(1)),
+		validation.Field(&c.Loans),
+		validation.Field(&c.Mail, validation.By(c.notServedMail)),
+		validation.Field(&c.PageSize),
+	)
+}
+
+func (l Loans) Validate() error {
+	return validation.ValidateStruct(&l,
+		validation.Field(&l.LoanDays, validation.Required, validation.Min(1)),
+		validation.Field(&l.RenewalDays, validation.Required, validation.Min(1)),
+		validation.Field(&l.MaxLoanDays, validation.Min(0)),
+		validation.Field(&l.MaxItemsOut, validation.Min(0)),
+		validation.Field(&l.MaxRenewals, validation.Min(0)),
+		validation.Field(&l.FinePerDay, validation.Min(0)),
+		validation.Field(&l.MaxBalance, validation.Min(0)),
+	)
+}
+
+func (m Mail) Validate() error {
+	var dirRules, smtpRules []validation.Rule
+	switch m.Backend {
+	case "file":
+		dirRules = []validation.Rule{validation.Required}
+	case "smtp":
+		smtpRules = []validation.Rule{validation.Required}
+	}
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Backend, validation.Required, validation.In("file", "smtp", "memory")),
+		validation.Field(&m.Dir, dirRules...),
+		validation.Field(&m.From, validation.Required),
+		validation.Field(&m.SMTPHost, smtpRules...),
+		validation.Field(&m.SMTPPort, append(smtpRules, validation.Min(1), validation.Max(65535))...),
+		validation.Field(&m.RetentionDays, validation.Required, validation.Min(1)),
+	)
+}
+
+func (p PageSizes) Validate() error {
+	positive := []validation.Rule{validation.Required, validation.Min(1), validation.Max(1000)}
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.Books, positive...),
+		validation.Field(&p.BooksMax, append(positive, validation.Min(p.Books))...),
+		validation.Field(&p.Categories, positive...),
+		validation.Field(&p.MyBookings, positive...),
+		validation.Field(&p.AllBookings, positive...),
+		validation.Field(&p.Ledger, positive...),
+		validation.Field(&p.API, positive...),
+		validation.Field(&p.APIMax, append(positive, validation.Min(p.API))...),
+	)
+}
+
+func absoluteURL(value interface{}) error {
+	u, err := url.Parse(value.(string))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("must be an absolute http(s) URL")
+	}
+	return nil
+}
+
+func subfolder(value interface{}) error {
+	dir := filepath.Clean(value.(string))
+	if filepath.IsAbs(dir) || dir == "." || dir == ".." || strings.HasPrefix(dir, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("must be a folder below the working directory")
+	}
+	return nil
+}
+
+// served reports whether path is inside the upload folder, whose files
+// anyone can download.
+func (c *Config) served(path string) bool {
+	rel, err := filepath.Rel(filepath.Clean(c.UploadDir), filepath.Clean(path))
+	if err != nil || filepath.IsAbs(rel) {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+func (c *Config) notServedDatabase(value interface{}) error {
+	path := strings.TrimPrefix(value.(string), "file:")
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+	if c.DatabaseDriver == "sqlite" && c.served(path) {
+		return fmt.Errorf("the SQLite database must not be inside upload_dir")
+	}
+	return nil
+}
+
+func (c *Config) notServedMail(value interface{}) error {
+	if m := value.(Mail); m.Backend == "file" && m.Dir != "" && c.served(m.Dir) {
+		return fmt.Errorf("dir: must not be inside upload_dir")
+	}
+	return nil
+}
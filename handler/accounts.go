@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"library/pagination"
+	"library/storage"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/gorilla/mux"
+)
+
+type LedgerEntry = storage.LedgerEntry
+
+// Account shows a member's balance and ledger. On the staff page of the
+// account it also holds the form for recording payments, waivers and
+// charges.
+type Account struct {
+	pagination.Page
+	User	storage.User
+	Balance	storage.Money
+	MaxBalance	storage.Money
+	Entries	[]LedgerEntry
+	Manage	bool
+	Entry	LedgerEntry
+	Errors	map[string]string
+	Access	Access
+}
+
+func validateLedgerEntry(e *LedgerEntry) error {
+	return validation.ValidateStruct(e,
+		validation.Field(&e.Kind,
+			validation.Required.Error("Please choose a type"),
+			validation.In(storage.LedgerPayment, storage.LedgerWaiver, storage.LedgerCharge).Error("Please choose a type"),
+		),
+		validation.Field(&e.Amount,
+			validation.Required.Error("The Amount Field is Required"),
+			validation.Min(1).Error("The Amount must be at least 0.01"),
+		),
+	)
+}
+
+func (h *Handler) myAccount(rw http.ResponseWriter, r *http.Request) {
+	h.loadAccount(rw, r, h.access(r).UserID, false, LedgerEntry{}, map[string]string{})
+}
+
+func (h *Handler) userAccount(rw http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(rw, "invalid URL", http.StatusInternalServerError)
+		return
+	}
+	h.loadAccount(rw, r, id, true, LedgerEntry{Kind: storage.LedgerPayment}, map[string]string{})
+}
+
+// storeLedgerEntry records a payment, waiver or charge taken at the desk.
+func (h *Handler) storeLedgerEntry(rw http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(rw, "invalid URL", http.StatusInternalServerError)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var entry LedgerEntry
+	if err := h.decoder.Decode(&entry, r.PostForm); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := validateLedgerEntry(&entry); err != nil {
+		vErrors, ok := err.(validation.Errors)
+		if ok {
+			vErrs := make(map[string]string)
+			for key, value := range vErrors {
+				vErrs[key] = value.Error()
+			}
+			h.loadAccount(rw, r, id, true, entry, vErrs)
+			return
+		}
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	staff := h.access(r).UserID
+	entry.UserID = id
+	entry.BookingID = nil
+	entry.CreatedBy = &staff
+	if err := h.ledger.AddLedgerEntry(r.Context(), &entry); err != nil {
+		if err == storage.ErrInvalidReference {
+			http.Error(rw, "invalid URL", http.StatusNotFound)
+			return
+		}
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(rw, r, r.URL.Path, http.StatusSeeOther)
+}
+
+func (h *Handler) loadAccount(rw http.ResponseWriter, r *http.Request, userID int, manage bool, entry LedgerEntry, errs map[string]string) {
+	user, err := h.users.User(r.Context(), userID)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			http.Error(rw, "invalid URL", http.StatusNotFound)
+			return
+		}
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	balance, err := h.ledger.Balance(r.Context(), userID)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	query := r.URL.Query()
+	page := pagination.FromQuery(query, h.cfg.PageSize.Ledger, h.cfg.PageSize.Ledger)
+	entries, total, err := h.ledger.LedgerEntries(r.Context(), userID, page.Offset, page.Limit)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	page.Build(r.URL.Path, query, total)
+	account := Account{
+		Page: page,
+		User: user,
+		Balance: balance,
+		MaxBalance: storage.Money(h.cfg.Loans.MaxBalance),
+		Entries: entries,
+		Manage: manage,
+		Entry: entry,
+		Errors: errs,
+		Access: h.access(r),
+	}
+	if err := h.templates.ExecuteTemplate(rw, "account.html", account); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,218 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"library/pagination"
+	"library/storage"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/gorilla/mux"
+)
+
+// The JSON API lives under /api/v1 next to the HTML pages. It shares the
+// models, validation and booking rules with them and only differs in how
+// requests are read and responses are written.
+
+type apiError struct {
+	Error  string            `json:"error"`
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+type apiList struct {
+	Data    interface{} `json:"data"`
+	Page    int         `json:"page"`
+	PerPage int         `json:"per_page"`
+	Total   int         `json:"total"`
+}
+
+// apiCursorList is a list paginated by cursor. Next is the URL of the next
+// page; both it and NextCursor are left out on the last page.
+type apiCursorList struct {
+	Data       interface{} `json:"data"`
+	PerPage    int         `json:"per_page"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+	Next       string      `json:"next,omitempty"`
+}
+
+type apiUser struct {
+	ID         int    `json:"id"`
+	FirstName  string `json:"first_name"`
+	LastName   string `json:"last_name"`
+	Email      string `json:"email"`
+	IsVerified bool   `json:"is_verified"`
+	Role       string `json:"role"`
+}
+
+func toAPIUser(u SignUp) apiUser {
+	return apiUser{
+		ID:         u.ID,
+		FirstName:  u.FirstName,
+		LastName:   u.LastName,
+		Email:      u.Email,
+		IsVerified: u.IsVerified,
+		Role:       u.Role,
+	}
+}
+
+func (h *Handler) registerAPI(r *mux.Router) {
+	api := r.PathPrefix("/api/v1").Subrouter()
+	api.Use(h.apiAuthMiddleware)
+	api.HandleFunc("/categories", h.apiListCategories).Methods("GET")
+	api.HandleFunc("/categories/{id:[0-9]+}", h.apiGetCategory).Methods("GET")
+	api.HandleFunc("/books", h.apiListBooks).Methods("GET")
+	api.HandleFunc("/books/{id:[0-9]+}", h.apiGetBook).Methods("GET")
+	api.HandleFunc("/books/{id:[0-9]+}/availability", h.apiBookAvailability).Methods("GET")
+	api.HandleFunc("/bookings", h.apiListBookings).Methods("GET")
+	api.HandleFunc("/bookings", h.apiCreateBooking).Methods("POST")
+	api.HandleFunc("/bookings/{id:[0-9]+}", h.apiGetBooking).Methods("GET")
+	api.HandleFunc("/bookings/{id:[0-9]+}/cancel", h.apiCancelBooking).Methods("POST")
+	api.HandleFunc("/bookings/{id:[0-9]+}/renew", h.apiRenewBooking).Methods("POST")
+	api.HandleFunc("/users/me", h.apiMe).Methods("GET")
+
+	c := api.NewRoute().Subrouter()
+	c.Use(h.apiPermissionMiddleware(permManageCatalog))
+	c.HandleFunc("/categories", h.apiCreateCategory).Methods("POST")
+	c.HandleFunc("/categories/{id:[0-9]+}", h.apiUpdateCategory).Methods("PUT")
+	c.HandleFunc("/categories/{id:[0-9]+}", h.apiDeleteCategory).Methods("DELETE")
+	c.HandleFunc("/books", h.apiCreateBook).Methods("POST")
+	c.HandleFunc("/books/{id:[0-9]+}", h.apiUpdateBook).Methods("PUT")
+	c.HandleFunc("/books/{id:[0-9]+}", h.apiDeleteBook).Methods("DELETE")
+
+	b := api.NewRoute().Subrouter()
+	b.Use(h.apiPermissionMiddleware(permManageBookings))
+	b.HandleFunc("/bookings/{id:[0-9]+}/checkout", h.apiCheckoutBooking).Methods("POST")
+	b.HandleFunc("/bookings/{id:[0-9]+}/checkin", h.apiCheckinBooking).Methods("POST")
+
+	u := api.NewRoute().Subrouter()
+	u.Use(h.apiPermissionMiddleware(permManageUsers))
+	u.HandleFunc("/users", h.apiListUsers).Methods("GET")
+	u.HandleFunc("/users/{id:[0-9]+}", h.apiGetUser).Methods("GET")
+
+	api.NotFoundHandler = http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		writeError(rw, http.StatusNotFound, "not found")
+	})
+}
+
+// apiAuthMiddleware works like authMiddleware but answers with 401 instead
+// of redirecting to the login page. Scripts should authenticate with a
+// bearer token, which tokenMiddleware has already checked by now.
+func (h *Handler) apiAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value(accessKey{}).(Access); ok {
+			// already authenticated by tokenMiddleware
+			next.ServeHTTP(rw, r)
+			return
+		}
+		access, ok := h.sessionAccess(r)
+		if !ok {
+			writeError(rw, http.StatusUnauthorized, "authentication required")
+			return
+		}
+		next.ServeHTTP(rw, withAccess(r, access))
+	})
+}
+
+func (h *Handler) apiPermissionMiddleware(perm string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			if !h.access(r).Can(perm) {
+				writeError(rw, http.StatusForbidden, "permission denied")
+				return
+			}
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+func writeJSON(rw http.ResponseWriter, status int, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(v)
+}
+
+func writeError(rw http.ResponseWriter, status int, msg string) {
+	writeJSON(rw, status, apiError{Error: msg})
+}
+
+// writeValidationError reports ozzo validation errors with 422. names maps
+// model field names to the JSON names the client sent.
+func writeValidationError(rw http.ResponseWriter, err error, names map[string]string) {
+	vErrors, ok := err.(validation.Errors)
+	if !ok {
+		writeError(rw, http.StatusInternalServerError, err.Error())
+		return
+	}
+	fields := make(map[string]string)
+	for key, value := range vErrors {
+		if name, ok := names[key]; ok {
+			key = name
+		}
+		fields[key] = value.Error()
+	}
+	writeJSON(rw, http.StatusUnprocessableEntity, apiError{Error: "validation failed", Fields: fields})
+}
+
+// writeLookupError reports a failed lookup of a single record, using 404
+// when the record does not exist.
+func writeLookupError(rw http.ResponseWriter, err error, notFound string) {
+	if err == storage.ErrNotFound {
+		writeError(rw, http.StatusNotFound, notFound)
+		return
+	}
+	writeError(rw, http.StatusInternalServerError, err.Error())
+}
+
+func decodeJSON(rw http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(rw, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func apiID(r *http.Request) int {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	return id
+}
+
+// apiPage reads page and per_page, defaulting to the first page of the
+// configured API page size.
+func (h *Handler) apiPage(r *http.Request) (page, perPage, offset int) {
+	p := pagination.FromQuery(r.URL.Query(), h.cfg.PageSize.API, h.cfg.PageSize.APIMax)
+	return p.CurrentPage, p.Limit, p.Offset
+}
+
+func (h *Handler) apiMe(rw http.ResponseWriter, r *http.Request) {
+	user, err := h.users.User(r.Context(), h.access(r).UserID)
+	if err != nil {
+		writeLookupError(rw, err, "user not found")
+		return
+	}
+	writeJSON(rw, http.StatusOK, toAPIUser(user))
+}
+
+func (h *Handler) apiListUsers(rw http.ResponseWriter, r *http.Request) {
+	page, perPage, offset := h.apiPage(r)
+	users, total, err := h.users.PageUsers(r.Context(), offset, perPage)
+	if err != nil {
+		writeError(rw, http.StatusInternalServerError, err.Error())
+		return
+	}
+	data := make([]apiUser, len(users))
+	for i, u := range users {
+		data[i] = toAPIUser(u)
+	}
+	writeJSON(rw, http.StatusOK, apiList{Data: data, Page: page, PerPage: perPage, Total: total})
+}
+
+func (h *Handler) apiGetUser(rw http.ResponseWriter, r *http.Request) {
+	user, err := h.users.User(r.Context(), apiID(r))
+	if err != nil {
+		writeLookupError(rw, err, "user not found")
+		return
+	}
+	writeJSON(rw, http.StatusOK, toAPIUser(user))
+}
@@ -0,0 +1,244 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"library/pagination"
+	"library/storage"
+)
+
+type apiBooking struct {
+	ID           int    `json:"id"`
+	UserID       int    `json:"user_id"`
+	BookID       int    `json:"book_id"`
+	BookName     string `json:"book_name,omitempty"`
+	CopyID       int    `json:"copy_id"`
+	Barcode      string `json:"barcode,omitempty"`
+	StartTime    string `json:"start_time"`
+	EndTime      string `json:"end_time"`
+	Status       string `json:"status"`
+	CheckedOutAt string `json:"checked_out_at,omitempty"`
+	ReturnedAt   string `json:"returned_at,omitempty"`
+	CancelledAt  string `json:"cancelled_at,omitempty"`
+	Renewals     int    `json:"renewals"`
+}
+
+var bookingFieldNames = map[string]string{"Start_time": "start_time", "End_time": "end_time"}
+
+func toAPIBooking(b Bookings) apiBooking {
+	return apiBooking{
+		ID:           b.ID,
+		UserID:       b.UserID,
+		BookID:       b.BookID,
+		BookName:     b.BookName,
+		CopyID:       b.CopyID,
+		Barcode:      b.Barcode,
+		StartTime:    b.StartTime.Format(bookingLayout),
+		EndTime:      b.EndTime.Format(bookingLayout),
+		Status:       b.Status,
+		CheckedOutAt: apiStamp(b.CheckedOutAt),
+		ReturnedAt:   apiStamp(b.ReturnedAt),
+		CancelledAt:  apiStamp(b.CancelledAt),
+		Renewals:     b.Renewals,
+	}
+}
+
+// apiStamp formats a status timestamp like the booking times, as the local
+// wall clock time it is stored as. It is empty for a nil time.
+func apiStamp(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(bookingLayout)
+}
+
+// apiBookingTime accepts RFC 3339 as well as the booking form layout and
+// normalises to the latter so validateBooking can be shared. Booking times
+// are local wall clock times, so RFC 3339 times are converted to the server's
+// zone first.
+func apiBookingTime(value string) string {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.In(time.Local).Format(bookingLayout)
+	}
+	return value
+}
+
+// apiListBookings pages by number, or by cursor when the cursor parameter is
+// present (empty for the first page), which stays fast on deep pages.
+func (h *Handler) apiListBookings(rw http.ResponseWriter, r *http.Request) {
+	page, perPage, offset := h.apiPage(r)
+	access := h.access(r)
+
+	filter := BookingFilter{UserID: access.UserID}
+	if r.URL.Query().Get("scope") == "all" && access.Can(permManageBookings) {
+		filter.UserID = 0
+		if err := h.decoder.Decode(&filter, r.URL.Query()); err != nil {
+			writeError(rw, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if _, ok := r.URL.Query()["cursor"]; ok {
+		h.apiBookingsByCursor(rw, r, filter)
+		return
+	}
+
+	booking, total, err := h.bookings.ListBookings(r.Context(), filter, offset, perPage)
+	if err != nil {
+		writeError(rw, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	data := make([]apiBooking, len(booking))
+	for i, b := range booking {
+		data[i] = toAPIBooking(b)
+	}
+	writeJSON(rw, http.StatusOK, apiList{Data: data, Page: page, PerPage: perPage, Total: total})
+}
+
+func (h *Handler) apiBookingsByCursor(rw http.ResponseWriter, r *http.Request, filter BookingFilter) {
+	page := pagination.KeysetFromQuery(r.URL.Query(), h.cfg.PageSize.API, h.cfg.PageSize.APIMax)
+	booking, err := h.pageBookings(r, filter, &page)
+	if err == errInvalidCursor {
+		writeError(rw, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(rw, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	data := make([]apiBooking, len(booking))
+	for i, b := range booking {
+		data[i] = toAPIBooking(b)
+	}
+	writeJSON(rw, http.StatusOK, apiCursorList{Data: data, PerPage: page.Limit, NextCursor: page.NextCursor, Next: page.NextPageURL})
+}
+
+// apiFindBooking loads a booking the caller may see. Members only see their
+// own bookings; anything else is reported as missing.
+func (h *Handler) apiFindBooking(rw http.ResponseWriter, r *http.Request) (Bookings, bool) {
+	booking, err := h.bookings.Booking(r.Context(), apiID(r))
+	if err != nil && err != storage.ErrNotFound {
+		writeError(rw, http.StatusInternalServerError, err.Error())
+		return booking, false
+	}
+	access := h.access(r)
+	if err == storage.ErrNotFound || (booking.UserID != access.UserID && !access.Can(permManageBookings)) {
+		writeError(rw, http.StatusNotFound, "booking not found")
+		return booking, false
+	}
+	return booking, true
+}
+
+func (h *Handler) apiGetBooking(rw http.ResponseWriter, r *http.Request) {
+	booking, ok := h.apiFindBooking(rw, r)
+	if !ok {
+		return
+	}
+	writeJSON(rw, http.StatusOK, toAPIBooking(booking))
+}
+
+func (h *Handler) apiCreateBooking(rw http.ResponseWriter, r *http.Request) {
+	var in apiBooking
+	if !decodeJSON(rw, r, &in) {
+		return
+	}
+	booking := Bookings{
+		BookID:     in.BookID,
+		Start_time: apiBookingTime(in.StartTime),
+		End_time:   apiBookingTime(in.EndTime),
+	}
+	if err := validateBooking(&booking); err != nil {
+		writeValidationError(rw, err, bookingFieldNames)
+		return
+	}
+	if _, err := h.books.Book(r.Context(), booking.BookID); err != nil {
+		if err == storage.ErrNotFound {
+			writeError(rw, http.StatusNotFound, "book not found")
+			return
+		}
+		writeError(rw, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	start, end := bookingWindow(&booking)
+	id, err := h.reserve(r.Context(), h.access(r).UserID, booking.BookID, start, end)
+	if err != nil {
+		if errors.As(err, new(policyError)) {
+			writeError(rw, http.StatusForbidden, err.Error())
+			return
+		}
+		if err == storage.ErrConflict {
+			writeError(rw, http.StatusConflict, h.conflictMessage(r.Context(), booking.BookID, start, end))
+			return
+		}
+		writeError(rw, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	created, err := h.bookings.Booking(r.Context(), id)
+	if err != nil {
+		writeError(rw, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(rw, http.StatusCreated, toAPIBooking(created))
+}
+
+func (h *Handler) apiCheckoutBooking(rw http.ResponseWriter, r *http.Request) {
+	h.apiChangeBookingStatus(rw, r, storage.BookingCheckedOut)
+}
+
+func (h *Handler) apiCheckinBooking(rw http.ResponseWriter, r *http.Request) {
+	h.apiChangeBookingStatus(rw, r, storage.BookingReturned)
+}
+
+func (h *Handler) apiCancelBooking(rw http.ResponseWriter, r *http.Request) {
+	h.apiChangeBookingStatus(rw, r, storage.BookingCancelled)
+}
+
+func (h *Handler) apiChangeBookingStatus(rw http.ResponseWriter, r *http.Request, to string) {
+	booking, ok := h.apiFindBooking(rw, r)
+	if !ok {
+		return
+	}
+	if err := h.transition(r.Context(), booking.ID, to, h.access(r).UserID); err != nil {
+		switch err {
+		case storage.ErrInvalidTransition:
+			writeError(rw, http.StatusConflict, err.Error())
+		case storage.ErrNotFound:
+			writeError(rw, http.StatusNotFound, "booking not found")
+		default:
+			writeError(rw, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	booking, err := h.bookings.Booking(r.Context(), booking.ID)
+	if err != nil {
+		writeError(rw, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(rw, http.StatusOK, toAPIBooking(booking))
+}
+
+func (h *Handler) apiRenewBooking(rw http.ResponseWriter, r *http.Request) {
+	booking, ok := h.apiFindBooking(rw, r)
+	if !ok {
+		return
+	}
+	if err := h.renew(r.Context(), booking, h.access(r).UserID); err != nil {
+		if msg, ok := renewMessages[err]; ok {
+			writeError(rw, http.StatusConflict, msg)
+			return
+		}
+		writeError(rw, http.StatusInternalServerError, err.Error())
+		return
+	}
+	booking, err := h.bookings.Booking(r.Context(), booking.ID)
+	if err != nil {
+		writeError(rw, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(rw, http.StatusOK, toAPIBooking(booking))
+}
@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"net/http"
+
+	"library/storage"
+)
+
+// apiBook is a book in the API. Image is read-only: it only changes when an
+// image is uploaded through the book form.
+type apiBook struct {
+	ID              int    `json:"id"`
+	CategoryID      int    `json:"category_id"`
+	CategoryName    string `json:"category_name"`
+	Name            string `json:"name"`
+	AuthorName      string `json:"author_name"`
+	Details         string `json:"details"`
+	Image           string `json:"image"`
+	Status          bool   `json:"status"`
+	Copies          int    `json:"copies,omitempty"`
+	TotalCopies     int    `json:"total_copies"`
+	AvailableCopies int    `json:"available_copies"`
+}
+
+var bookFieldNames = map[string]string{
+	"Category_id": "category_id",
+	"Book_name":   "name",
+	"AuthorName":  "author_name",
+	"Details":     "details",
+}
+
+func toAPIBook(b Book) apiBook {
+	return apiBook{
+		ID:              b.ID,
+		CategoryID:      b.Category_id,
+		CategoryName:    b.Cat_name,
+		Name:            b.Book_name,
+		AuthorName:      b.AuthorName,
+		Details:         b.Details,
+		Image:           b.Image,
+		Status:          b.Status,
+		TotalCopies:     b.TotalCopies,
+		AvailableCopies: b.AvailableCopies,
+	}
+}
+
+func (a apiBook) model() Book {
+	return Book{
+		ID:          a.ID,
+		Category_id: a.CategoryID,
+		Book_name:   a.Name,
+		AuthorName:  a.AuthorName,
+		Details:     a.Details,
+		Status:      a.Status,
+		Copies:      a.Copies,
+	}
+}
+
+func (h *Handler) apiListBooks(rw http.ResponseWriter, r *http.Request) {
+	page, perPage, offset := h.apiPage(r)
+	book, total, err := h.books.PageBooks(r.Context(), offset, perPage)
+	if err != nil {
+		writeError(rw, http.StatusInternalServerError, err.Error())
+		return
+	}
+	data := make([]apiBook, len(book))
+	for i, b := range book {
+		data[i] = toAPIBook(b)
+	}
+	writeJSON(rw, http.StatusOK, apiList{Data: data, Page: page, PerPage: perPage, Total: total})
+}
+
+func (h *Handler) apiGetBook(rw http.ResponseWriter, r *http.Request) {
+	book, err := h.books.Book(r.Context(), apiID(r))
+	if err != nil {
+		writeLookupError(rw, err, "book not found")
+		return
+	}
+	writeJSON(rw, http.StatusOK, toAPIBook(book))
+}
+
+func (h *Handler) apiCreateBook(rw http.ResponseWriter, r *http.Request) {
+	var in apiBook
+	if !decodeJSON(rw, r, &in) {
+		return
+	}
+	book := in.model()
+	if err := validateBook(&book); err != nil {
+		writeValidationError(rw, err, bookFieldNames)
+		return
+	}
+	if err := h.books.CreateBook(r.Context(), &book); err != nil {
+		writeBookError(rw, err)
+		return
+	}
+	h.writeBook(rw, r, http.StatusCreated, book.ID)
+}
+
+func (h *Handler) apiUpdateBook(rw http.ResponseWriter, r *http.Request) {
+	book, err := h.books.Book(r.Context(), apiID(r))
+	if err != nil {
+		writeLookupError(rw, err, "book not found")
+		return
+	}
+	in := toAPIBook(book)
+	if !decodeJSON(rw, r, &in) {
+		return
+	}
+	in.ID = book.ID
+	image := book.Image
+	book = in.model()
+	book.Image = image
+	if err := validateBook(&book); err != nil {
+		writeValidationError(rw, err, bookFieldNames)
+		return
+	}
+	if err := h.books.UpdateBook(r.Context(), book); err != nil {
+		writeBookError(rw, err)
+		return
+	}
+	h.writeBook(rw, r, http.StatusOK, book.ID)
+}
+
+func (h *Handler) apiDeleteBook(rw http.ResponseWriter, r *http.Request) {
+	if err := h.books.DeleteBook(r.Context(), apiID(r)); err != nil {
+		if err == storage.ErrActiveBookings {
+			writeError(rw, http.StatusConflict, "the book still has reserved or checked out bookings")
+			return
+		}
+		writeLookupError(rw, err, "book not found")
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// writeBook responds with the stored book, including the fields the store
+// fills in.
+func (h *Handler) writeBook(rw http.ResponseWriter, r *http.Request, status int, id int) {
+	book, err := h.books.Book(r.Context(), id)
+	if err != nil {
+		writeLookupError(rw, err, "book not found")
+		return
+	}
+	writeJSON(rw, status, toAPIBook(book))
+}
+
+// writeBookError reports a failed book write, turning an unknown category
+// into a validation error.
+func writeBookError(rw http.ResponseWriter, err error) {
+	if err == storage.ErrInvalidReference {
+		writeJSON(rw, http.StatusUnprocessableEntity, apiError{Error: "validation failed", Fields: map[string]string{"category_id": "category does not exist"}})
+		return
+	}
+	writeError(rw, http.StatusInternalServerError, err.Error())
+}
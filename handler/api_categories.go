@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"library/storage"
+)
+
+type apiCategory struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	Status bool   `json:"status"`
+}
+
+var categoryFieldNames = map[string]string{"Name": "name", "Status": "status"}
+
+func toAPICategory(c Category) apiCategory {
+	return apiCategory{ID: c.ID, Name: c.Name, Status: c.Status}
+}
+
+func (a apiCategory) model() Category {
+	return Category{ID: a.ID, Name: a.Name, Status: a.Status}
+}
+
+func (h *Handler) apiListCategories(rw http.ResponseWriter, r *http.Request) {
+	page, perPage, offset := h.apiPage(r)
+	category, total, err := h.categories.PageCategories(r.Context(), offset, perPage)
+	if err != nil {
+		writeError(rw, http.StatusInternalServerError, err.Error())
+		return
+	}
+	data := make([]apiCategory, len(category))
+	for i, c := range category {
+		data[i] = toAPICategory(c)
+	}
+	writeJSON(rw, http.StatusOK, apiList{Data: data, Page: page, PerPage: perPage, Total: total})
+}
+
+func (h *Handler) apiGetCategory(rw http.ResponseWriter, r *http.Request) {
+	category, err := h.categories.Category(r.Context(), apiID(r))
+	if err != nil {
+		writeLookupError(rw, err, "category not found")
+		return
+	}
+	writeJSON(rw, http.StatusOK, toAPICategory(category))
+}
+
+func (h *Handler) apiCreateCategory(rw http.ResponseWriter, r *http.Request) {
+	var in apiCategory
+	if !decodeJSON(rw, r, &in) {
+		return
+	}
+	category := in.model()
+	if err := validateCategory(&category); err != nil {
+		writeValidationError(rw, err, categoryFieldNames)
+		return
+	}
+	if err := h.categories.CreateCategory(r.Context(), &category); err != nil {
+		writeError(rw, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(rw, http.StatusCreated, toAPICategory(category))
+}
+
+func (h *Handler) apiUpdateCategory(rw http.ResponseWriter, r *http.Request) {
+	category, err := h.categories.Category(r.Context(), apiID(r))
+	if err != nil {
+		writeLookupError(rw, err, "category not found")
+		return
+	}
+	in := toAPICategory(category)
+	if !decodeJSON(rw, r, &in) {
+		return
+	}
+	in.ID = category.ID
+	category = in.model()
+	if err := validateCategory(&category); err != nil {
+		writeValidationError(rw, err, categoryFieldNames)
+		return
+	}
+	if err := h.categories.UpdateCategory(r.Context(), category); err != nil {
+		writeLookupError(rw, err, "category not found")
+		return
+	}
+	writeJSON(rw, http.StatusOK, toAPICategory(category))
+}
+
+// apiDeleteCategory refuses to delete a category that still has books
+// unless reassign_to names the category they should move to.
+func (h *Handler) apiDeleteCategory(rw http.ResponseWriter, r *http.Request) {
+	id := apiID(r)
+	if _, err := h.categories.Category(r.Context(), id); err != nil {
+		writeLookupError(rw, err, "category not found")
+		return
+	}
+	target := 0
+	if v := r.URL.Query().Get("reassign_to"); v != "" {
+		target, _ = strconv.Atoi(v)
+		if _, err := h.categories.Category(r.Context(), target); target == id || err != nil {
+			writeJSON(rw, http.StatusUnprocessableEntity, apiError{Error: "validation failed", Fields: map[string]string{"reassign_to": "must be another existing category"}})
+			return
+		}
+	}
+	if err := h.categories.DeleteCategory(r.Context(), id, target); err != nil {
+		switch err {
+		case storage.ErrInvalidReference:
+			writeJSON(rw, http.StatusUnprocessableEntity, apiError{Error: "validation failed", Fields: map[string]string{"reassign_to": "must be another existing category"}})
+		case storage.ErrInUse:
+			writeError(rw, http.StatusConflict, "the category still has books; pass reassign_to to move them")
+		default:
+			writeLookupError(rw, err, "category not found")
+		}
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
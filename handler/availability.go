@@ -0,0 +1,219 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"library/storage"
+)
+
+// dateLayout is how calendar dates are passed in query parameters.
+const dateLayout = "2006-01-02"
+
+// calendarDays is how many days the calendar on a book's page shows, and
+// maxCalendarDays the most the availability API returns at once.
+const (
+	calendarDays    = 14
+	maxCalendarDays = 90
+)
+
+// Availability is the booking calendar of a title for the days from From.
+type Availability struct {
+	From	time.Time
+	Days	[]time.Time
+	Copies	[]CopyCalendar
+	// NextFree is when a copy is next free for Suggested, or nil when the
+	// title has no copies in circulation.
+	NextFree	*time.Time
+	// Suggested is a free booking of the length the viewer may borrow the
+	// book for, in the layout of the booking form.
+	Suggested	Bookings
+	PreviousURL	string
+	NextURL	string
+}
+
+// CopyCalendar is the calendar of one copy in circulation.
+type CopyCalendar struct {
+	Copy	BookCopy
+	Bookings	[]Bookings
+	// Busy tells for each day of the calendar whether the copy is booked
+	// for some of it.
+	Busy	[]bool
+}
+
+// calendarStart reads the first day of the calendar from the from
+// parameter, and defaults to today.
+func calendarStart(r *http.Request) time.Time {
+	if from, err := time.Parse(dateLayout, r.URL.Query().Get("from")); err == nil {
+		return from
+	}
+	return storage.WallClock().Truncate(24 * time.Hour)
+}
+
+// availability builds the calendar of the book's copies for days days from
+// from, and suggests the next free booking for userID.
+func (h *Handler) availability(ctx context.Context, book Book, userID int, from time.Time, days int) (Availability, error) {
+	to := from.AddDate(0, 0, days)
+	a := Availability{From: from}
+	for d := from; d.Before(to); d = d.AddDate(0, 0, 1) {
+		a.Days = append(a.Days, d)
+	}
+
+	copies, err := h.books.Copies(ctx, book.ID)
+	if err != nil {
+		return a, err
+	}
+	bookings, err := h.bookings.Schedule(ctx, book.ID, from, to)
+	if err != nil {
+		return a, err
+	}
+	now := storage.WallClock()
+	for _, c := range copies {
+		if !c.Status {
+			continue
+		}
+		cal := CopyCalendar{Copy: c, Busy: make([]bool, len(a.Days))}
+		for _, b := range bookings {
+			if b.CopyID != c.ID {
+				continue
+			}
+			// an overdue loan keeps the copy until it is checked in
+			end := b.EndTime
+			if b.Status == storage.BookingCheckedOut && end.Before(now) {
+				end = now
+			}
+			for i, day := range a.Days {
+				if b.StartTime.Before(day.AddDate(0, 0, 1)) && end.After(day) {
+					cal.Busy[i] = true
+				}
+			}
+			cal.Bookings = append(cal.Bookings, b)
+		}
+		fillBookingDetails(cal.Bookings)
+		a.Copies = append(a.Copies, cal)
+	}
+
+	limits, err := h.limits(ctx, userID, book.ID)
+	if err != nil {
+		return a, err
+	}
+	loanDays := h.cfg.Loans.LoanDays
+	if limits.MaxLoanDays > 0 && limits.MaxLoanDays < loanDays {
+		loanDays = limits.MaxLoanDays
+	}
+	length := time.Duration(loanDays) * 24 * time.Hour
+	// the booking form takes whole minutes
+	start := now.Truncate(time.Minute).Add(time.Minute)
+	next, ok, err := h.bookings.NextFreeSlot(ctx, book.ID, start, length)
+	if err != nil {
+		return a, err
+	}
+	if ok {
+		a.NextFree = &next
+		a.Suggested = Bookings{
+			BookID: book.ID,
+			Start_time: next.Format(bookingLayout),
+			End_time: next.Add(length).Format(bookingLayout),
+		}
+	}
+	return a, nil
+}
+
+func (h *Handler) bookDetails(rw http.ResponseWriter, r *http.Request) {
+	book, ok := h.getBookFromURL(rw, r)
+	if !ok {
+		return
+	}
+	from := calendarStart(r)
+	availability, err := h.availability(r.Context(), book, h.access(r).UserID, from, calendarDays)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	availability.PreviousURL = fmt.Sprintf("%s?from=%s", r.URL.Path, from.AddDate(0, 0, -calendarDays).Format(dateLayout))
+	availability.NextURL = fmt.Sprintf("%s?from=%s", r.URL.Path, from.AddDate(0, 0, calendarDays).Format(dateLayout))
+
+	details := BookDetails{
+		Book: book,
+		Availability: availability,
+		Access: h.access(r),
+	}
+	if err:= h.templates.ExecuteTemplate(rw, "single-details.html", details); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+type apiAvailability struct {
+	BookID         int               `json:"book_id"`
+	From           string            `json:"from"`
+	To             string            `json:"to"`
+	NextAvailable  string            `json:"next_available,omitempty"`
+	SuggestedStart string            `json:"suggested_start,omitempty"`
+	SuggestedEnd   string            `json:"suggested_end,omitempty"`
+	Copies         []apiCopyCalendar `json:"copies"`
+}
+
+type apiCopyCalendar struct {
+	ID       int           `json:"id"`
+	Barcode  string        `json:"barcode"`
+	Busy     []string      `json:"busy_days"`
+	Bookings []apiSchedule `json:"bookings"`
+}
+
+// apiSchedule is a booking on the calendar. It leaves out who booked.
+type apiSchedule struct {
+	StartTime string `json:"start_time"`
+	EndTime   string `json:"end_time"`
+	Status    string `json:"status"`
+}
+
+func toAPIAvailability(book Book, a Availability) apiAvailability {
+	out := apiAvailability{
+		BookID: book.ID,
+		From:   a.From.Format(dateLayout),
+		To:     a.From.AddDate(0, 0, len(a.Days)).Format(dateLayout),
+		Copies: make([]apiCopyCalendar, len(a.Copies)),
+	}
+	if a.NextFree != nil {
+		out.NextAvailable = a.NextFree.Format(bookingLayout)
+		out.SuggestedStart = a.Suggested.Start_time
+		out.SuggestedEnd = a.Suggested.End_time
+	}
+	for i, c := range a.Copies {
+		cal := apiCopyCalendar{ID: c.Copy.ID, Barcode: c.Copy.Barcode, Busy: []string{}, Bookings: make([]apiSchedule, len(c.Bookings))}
+		for j, busy := range c.Busy {
+			if busy {
+				cal.Busy = append(cal.Busy, a.Days[j].Format(dateLayout))
+			}
+		}
+		for j, b := range c.Bookings {
+			cal.Bookings[j] = apiSchedule{StartTime: b.StartTime.Format(bookingLayout), EndTime: b.EndTime.Format(bookingLayout), Status: b.Status}
+		}
+		out.Copies[i] = cal
+	}
+	return out
+}
+
+// apiBookAvailability returns the calendar of a book for the days parameter
+// (14 by default, at most 90) from the from parameter (today by default).
+func (h *Handler) apiBookAvailability(rw http.ResponseWriter, r *http.Request) {
+	book, err := h.books.Book(r.Context(), apiID(r))
+	if err != nil {
+		writeLookupError(rw, err, "book not found")
+		return
+	}
+	days, err := strconv.Atoi(r.URL.Query().Get("days"))
+	if err != nil || days < 1 || days > maxCalendarDays {
+		days = calendarDays
+	}
+	availability, err := h.availability(r.Context(), book, h.access(r).UserID, calendarStart(r), days)
+	if err != nil {
+		writeError(rw, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(rw, http.StatusOK, toAPIAvailability(book, availability))
+}
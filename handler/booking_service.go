@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"library/storage"
+)
+
+// bookingLayout is the format sent by the datetime-local inputs on the
+// booking form. Booking times are stored as plain timestamps, so they are
+// parsed without a location and compared as wall clock times.
+const bookingLayout = "2006-01-02T15:04"
+
+// displayLayout is how booking times are shown back to users.
+const displayLayout = "Mon Jan _2 2006 15:04"
+
+// policyError refuses a booking the lending policy does not allow, such as
+// one that is too long or made while the member owes too much. The message
+// is shown to the member.
+type policyError string
+
+func (e policyError) Error() string {
+	return string(e)
+}
+
+// limits returns the lending limits for a loan of the book to the user: the
+// defaults of the loan policy with the matching circulation policies
+// applied.
+func (h *Handler) limits(ctx context.Context, userID, bookID int) (storage.Limits, error) {
+	book, err := h.books.Book(ctx, bookID)
+	if err != nil {
+		return storage.Limits{}, err
+	}
+	user, err := h.users.User(ctx, userID)
+	if err != nil {
+		return storage.Limits{}, err
+	}
+	policies, err := h.policies.MatchingPolicies(ctx, book.Category_id, user.Role)
+	if err != nil {
+		return storage.Limits{}, err
+	}
+	defaults := storage.Limits{
+		MaxLoanDays: h.cfg.Loans.MaxLoanDays,
+		MaxItemsOut: h.cfg.Loans.MaxItemsOut,
+		MaxRenewals: h.cfg.Loans.MaxRenewals,
+		FinePerDay:  storage.Money(h.cfg.Loans.FinePerDay),
+	}
+	return defaults.Apply(policies), nil
+}
+
+// reserve books a free copy for the window, returns the new booking ID and
+// mails the confirmation. It returns a policyError instead while the user
+// owes too much, has too many books out or asks for too long a loan.
+func (h *Handler) reserve(ctx context.Context, userID int, bookID int, start, end time.Time) (int, error) {
+	balance, err := h.ledger.Balance(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	if max := storage.Money(h.cfg.Loans.MaxBalance); balance > max {
+		return 0, policyError(fmt.Sprintf("Your account balance of %s is above the limit of %s. Please settle it before making new bookings.", balance, max))
+	}
+	limits, err := h.limits(ctx, userID, bookID)
+	if err != nil {
+		return 0, err
+	}
+	if days := limits.MaxLoanDays; days > 0 && end.Sub(start) > time.Duration(days)*24*time.Hour {
+		return 0, policyError(fmt.Sprintf("This book can be borrowed for at most %d days.", days))
+	}
+	id, err := h.bookings.Reserve(ctx, userID, bookID, start, end, limits.MaxItemsOut)
+	if err == storage.ErrItemsOutLimit {
+		return 0, policyError(fmt.Sprintf("You have reached your limit on reserved and checked out books (%d at a time). Please return one before booking another.", limits.MaxItemsOut))
+	}
+	if err != nil {
+		return 0, err
+	}
+	h.notifyBooking(ctx, id)
+	return id, nil
+}
+
+// conflictMessage explains why a window could not be booked and, when one
+// exists, when the title can next be booked for the same length.
+func (h *Handler) conflictMessage(ctx context.Context, bookID int, start, end time.Time) string {
+	msg := fmt.Sprintf("All copies are booked between %s and %s.", start.Format(displayLayout), end.Format(displayLayout))
+	next, ok, err := h.bookings.NextFreeSlot(ctx, bookID, start, end.Sub(start))
+	if err != nil {
+		return msg
+	}
+	if !ok {
+		return msg + " This book has no copies in circulation."
+	}
+	// free by bookings alone, so the copies are kept for the waitlist
+	if !next.After(start) {
+		return msg + " The free copies are kept for members on the waitlist; join it to get the next one."
+	}
+	return msg + fmt.Sprintf(" The next free slot is %s to %s.", next.Format(displayLayout), next.Add(end.Sub(start)).Format(displayLayout))
+}
+
+// transition moves a booking to a new status and mails the owner about it.
+func (h *Handler) transition(ctx context.Context, bookingID int, to string, performedBy int) error {
+	if err := h.bookings.Transition(ctx, bookingID, to, performedBy); err != nil {
+		return err
+	}
+	h.notifyBooking(ctx, bookingID)
+	return nil
+}
+
+// renew extends a booking by the renewal period of the loan policy, up to
+// the renewals the owner's limits allow, and mails the owner the new end
+// time.
+func (h *Handler) renew(ctx context.Context, booking Bookings, performedBy int) error {
+	limits, err := h.limits(ctx, booking.UserID, booking.BookID)
+	if err != nil {
+		return err
+	}
+	period := time.Duration(h.cfg.Loans.RenewalDays) * 24 * time.Hour
+	if err := h.bookings.Renew(ctx, booking.ID, period, limits.MaxRenewals, performedBy); err != nil {
+		return err
+	}
+	h.notifyRenewal(ctx, booking.ID)
+	return nil
+}
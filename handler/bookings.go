@@ -1,26 +1,19 @@
 package handler
 
 import (
-	"fmt"
-	"math"
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
 
+	"library/pagination"
+	"library/storage"
+
 	validation "github.com/go-ozzo/ozzo-validation"
 	"github.com/gorilla/mux"
 )
 
-type Bookings struct {
-	ID int `db:"id"`
-	UserID int `db:"user_id"`
-	BookID int `db:"book_id"`
-	StartTime time.Time `db:"start_time"`
-	EndTime time.Time `db:"end_time"`
-	Start_time string
-	End_time string
-	BookName string
-}
+type Bookings = storage.Booking
 
 type FormBookings struct {
 	Id int
@@ -29,33 +22,80 @@ type FormBookings struct {
 }
 
 type MyBookings struct {
+	pagination.Keyset
 	Booking []Bookings
-	Offset	int
-	Limit	int
-	Total	int
-	TotalPage	int
-	Paginate	[]BookingPagination
-	CurrentPage	int
-	NextPageURL	string
-	PreviousPageURL	string
+	Holds	[]Hold
+	Access	Access
 }
 
-type BookingPagination struct {
-	URL	string
-	PageNumber	int
+type BookingFilter = storage.BookingFilter
+
+type AllBookings struct {
+	MyBookings
+	Filter	BookingFilter
+	Books	[]Book
 }
 
-func (b *Bookings) Validate() error {
+func validateBooking(b *Bookings) error {
 	return validation.ValidateStruct(b,
 		validation.Field(&b.Start_time,
 			validation.Required.Error("The Start Time Field is Required"),
+			validation.By(isBookingTime),
+			validation.By(notInPast),
 		),
 		validation.Field(&b.End_time,
 			validation.Required.Error("The End Time Field is Required"),
+			validation.By(isBookingTime),
+			validation.By(endsAfter(b.Start_time)),
 		),
 	)
 }
 
+func isBookingTime(value interface{}) error {
+	if _, err := time.Parse(bookingLayout, value.(string)); err != nil {
+		return errors.New("The Time is not valid")
+	}
+	return nil
+}
+
+// notInPast checks that a booking time is not before the current minute.
+// Booking times are wall clock times, so they are compared with the wall
+// clock. An unparsable time is reported by isBookingTime.
+func notInPast(value interface{}) error {
+	t, err := time.Parse(bookingLayout, value.(string))
+	if err != nil {
+		return nil
+	}
+	if t.Before(storage.WallClock().Truncate(time.Minute)) {
+		return errors.New("The Start Time cannot be in the past")
+	}
+	return nil
+}
+
+// endsAfter checks that an end time comes after startTime. An unparsable
+// start is reported by its own field.
+func endsAfter(startTime string) validation.RuleFunc {
+	return func(value interface{}) error {
+		start, err := time.Parse(bookingLayout, startTime)
+		if err != nil {
+			return nil
+		}
+		end, _ := time.Parse(bookingLayout, value.(string))
+		if !end.After(start) {
+			return errors.New("The End Time must be after the Start Time")
+		}
+		return nil
+	}
+}
+
+// bookingWindow returns the parsed booking times. It must only be called
+// once validateBooking has succeeded.
+func bookingWindow(b *Bookings) (time.Time, time.Time) {
+	start, _ := time.Parse(bookingLayout, b.Start_time)
+	end, _ := time.Parse(bookingLayout, b.End_time)
+	return start, end
+}
+
 func (h *Handler) createBookings(rw http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
@@ -68,10 +108,7 @@ func (h *Handler) createBookings(rw http.ResponseWriter, r *http.Request) {
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	const getbook = "SELECT * FROM books WHERE id = $1"
-	var book Book
-	h.db.Get(&book, getbook, i)
-	
+
 	vErrs := map[string]string{}
 	booking := Bookings{}
 	h.loadCreateBookingForm(rw, i, booking, vErrs)
@@ -87,25 +124,30 @@ func(h *Handler) storeBookings(rw http.ResponseWriter, r *http.Request) {
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	if err := booking.Validate(); err != nil {
+	if err := validateBooking(&booking); err != nil {
 		vErrors, ok := err.(validation.Errors)
 		if ok {
 			vErrs := make(map[string]string)
 			for key, value := range vErrors {
 				vErrs[key] = value.Error()
 			}
-			h.loadCreateBookingForm(rw, booking.ID, booking, vErrs)
+			h.loadCreateBookingForm(rw, booking.BookID, booking, vErrs)
 			return
 		}
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	const insertBooking = `INSERT INTO bookings(user_id,book_id,Start_time,end_time) VALUES($1,$2,$3,$4)`
-	res:= h.db.MustExec(insertBooking, 1, booking.BookID, booking.Start_time, booking.End_time)
-	getBook:= `UPDATE books SET status = false WHERE id = $1`
-	h.db.MustExec(getBook, booking.BookID)
-
-	if ok , err:= res.RowsAffected(); err != nil || ok == 0 {
+	start, end := bookingWindow(&booking)
+	if _, err := h.reserve(r.Context(), h.access(r).UserID, booking.BookID, start, end); err != nil {
+		if errors.As(err, new(policyError)) {
+			h.loadCreateBookingForm(rw, booking.BookID, booking, map[string]string{"Conflict": err.Error()})
+			return
+		}
+		if err == storage.ErrConflict {
+			vErrs := map[string]string{"Conflict": h.conflictMessage(r.Context(), booking.BookID, start, end)}
+			h.loadCreateBookingForm(rw, booking.BookID, booking, vErrs)
+			return
+		}
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -125,66 +167,188 @@ func (h *Handler) loadCreateBookingForm(rw http.ResponseWriter, id int, booking
 }
 
 func(h *Handler) myBookings(rw http.ResponseWriter, r *http.Request) {
-	page := r.URL.Query().Get("page")
-	var p int = 1
-	var err error
-	if page != "" {
-		p, err = strconv.Atoi(page)
+	page := pagination.KeysetFromQuery(r.URL.Query(), h.cfg.PageSize.MyBookings, h.cfg.PageSize.MyBookings)
+	booking, err := h.pageBookings(r, BookingFilter{UserID: h.access(r).UserID}, &page)
+	if err == errInvalidCursor {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
 	}
 	if err != nil {
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	booking := []Bookings{}
-	offset := 0
-	limit := 4
-	if p > 0 {
-		offset = limit * p - limit
-	}
-	h.db.Select(&booking, "SELECT * FROM bookings offset $1 limit $2", offset, limit)
-	total := 0
-	h.db.Get(&total, "SELECT count(*) FROM bookings")
-	nextPageURL := ""
-	previousPageURL := ""
-	totalPage := int(math.Ceil(float64(total)/float64(limit)))
-	bookingPaginate := make([]BookingPagination, totalPage)
-	for i := 0; i < totalPage; i++ {
-		bookingPaginate[i] = BookingPagination{
-			URL: fmt.Sprintf("http://localhost:3000/mybookings?page=%d", i + 1),
-			PageNumber: i + 1,
-		}
-		if i + 1 == p {
-			if i != 0 {
-				previousPageURL = fmt.Sprintf("http://localhost:3000/mybookings?page=%d", i)
-			}
-			if i + 1 != totalPage {
-				nextPageURL = fmt.Sprintf("http://localhost:3000/mybookings?page=%d", i + 2)
-			}
-		}
-	}
-	for key, value := range booking {
-		const getBook = `SELECT book_name FROM books WHERE id = $1`
-		var book Book
-		h.db.Get(&book, getBook, value.BookID)
-		start_time:= value.StartTime.Format("Mon Jan _2 2006 15:04 AM")
-		end_time:= value.EndTime.Format("Mon Jan _2 2006 15:04 AM")
-		booking[key].BookName = book.Book_name
-		booking[key].Start_time = start_time
-		booking[key].End_time = end_time
+	fillBookingDetails(booking)
+	holds, err := h.holds.UserHolds(r.Context(), h.access(r).UserID)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
 	}
+
 	list := MyBookings{
+		Keyset: page,
 		Booking: booking,
-		Offset: offset,
-		Limit: limit,
-		Total: total,
-		TotalPage: totalPage,
-		Paginate: bookingPaginate,
-		CurrentPage: p,
-		NextPageURL: nextPageURL,
-		PreviousPageURL: previousPageURL,
+		Holds: holds,
+		Access: h.access(r),
 	}
 	if err:= h.templates.ExecuteTemplate(rw, "my-bookings.html", list); err != nil {
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
-}
\ No newline at end of file
+}
+
+func (h *Handler) checkoutBooking(rw http.ResponseWriter, r *http.Request) {
+	h.changeBookingStatus(rw, r, storage.BookingCheckedOut)
+}
+
+func (h *Handler) checkinBooking(rw http.ResponseWriter, r *http.Request) {
+	h.changeBookingStatus(rw, r, storage.BookingReturned)
+}
+
+func (h *Handler) cancelBooking(rw http.ResponseWriter, r *http.Request) {
+	h.changeBookingStatus(rw, r, storage.BookingCancelled)
+}
+
+func (h *Handler) changeBookingStatus(rw http.ResponseWriter, r *http.Request, to string) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(rw, "invalid URL", http.StatusInternalServerError)
+		return
+	}
+	access := h.access(r)
+	userID := access.UserID
+	if to == storage.BookingCancelled && !access.Can(permManageBookings) {
+		booking, err := h.bookings.Booking(r.Context(), id)
+		if err != nil && err != storage.ErrNotFound {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if booking.UserID != userID {
+			http.Error(rw, "you can only cancel your own bookings", http.StatusForbidden)
+			return
+		}
+	}
+	if err := h.transition(r.Context(), id, to, userID); err != nil {
+		if err == storage.ErrInvalidTransition {
+			http.Error(rw, err.Error(), http.StatusConflict)
+			return
+		}
+		if err == storage.ErrNotFound {
+			http.Error(rw, "invalid URL", http.StatusInternalServerError)
+			return
+		}
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	redirectBack(rw, r, "/mybookings")
+}
+
+// renewMessages explain why a renewal was refused.
+var renewMessages = map[error]string{
+	storage.ErrNotRenewable: storage.ErrNotRenewable.Error(),
+	storage.ErrRenewalLimit: storage.ErrRenewalLimit.Error(),
+	storage.ErrHoldsWaiting: "Other members are waiting for this book, so it cannot be renewed. Please return it by the end of the booking.",
+	storage.ErrConflict:     "The copy is booked by someone else right after this booking, so it cannot be renewed.",
+}
+
+func (h *Handler) renewBooking(rw http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(rw, "invalid URL", http.StatusInternalServerError)
+		return
+	}
+	access := h.access(r)
+	booking, err := h.bookings.Booking(r.Context(), id)
+	if err != nil && err != storage.ErrNotFound {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err == storage.ErrNotFound || (booking.UserID != access.UserID && !access.Can(permManageBookings)) {
+		http.Error(rw, "invalid URL", http.StatusNotFound)
+		return
+	}
+	if err := h.renew(r.Context(), booking, access.UserID); err != nil {
+		if msg, ok := renewMessages[err]; ok {
+			http.Error(rw, msg, http.StatusConflict)
+			return
+		}
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	redirectBack(rw, r, "/mybookings")
+}
+
+func (h *Handler) allBookings(rw http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var filter BookingFilter
+	if err := h.decoder.Decode(&filter, query); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	page := pagination.KeysetFromQuery(query, h.cfg.PageSize.AllBookings, h.cfg.PageSize.AllBookings)
+	booking, err := h.pageBookings(r, filter, &page)
+	if err == errInvalidCursor {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fillBookingDetails(booking)
+
+	books, err := h.books.Books(r.Context())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	list := AllBookings{
+		MyBookings: MyBookings{
+			Keyset: page,
+			Booking: booking,
+			Access: h.access(r),
+		},
+		Filter: filter,
+		Books: books,
+	}
+	if err:= h.templates.ExecuteTemplate(rw, "all-bookings.html", list); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// pageBookings loads the bookings on the page and builds its links. It asks
+// for one extra row to tell whether there is a next page.
+func (h *Handler) pageBookings(r *http.Request, filter BookingFilter, page *pagination.Keyset) ([]Bookings, error) {
+	var cursor storage.BookingCursor
+	ok, err := page.Decode(&cursor)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	var after *storage.BookingCursor
+	if ok {
+		after = &cursor
+	}
+
+	booking, err := h.bookings.BookingsAfter(r.Context(), filter, after, page.Limit+1)
+	if err != nil {
+		return nil, err
+	}
+	var next interface{}
+	if len(booking) > page.Limit {
+		booking = booking[:page.Limit]
+		last := booking[len(booking)-1]
+		next = storage.BookingCursor{StartTime: last.StartTime, ID: last.ID}
+	}
+	return booking, page.Build(r.URL.Path, r.URL.Query(), next)
+}
+
+// fillBookingDetails formats the booking times shown in booking lists.
+func fillBookingDetails(booking []Bookings) {
+	for key, value := range booking {
+		booking[key].Start_time = value.StartTime.Format(displayLayout)
+		booking[key].End_time = value.EndTime.Format(displayLayout)
+	}
+}
@@ -0,0 +1,196 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"library/storage"
+)
+
+// window returns a booking body for the book from days days ahead, lasting
+// length.
+func window(bookID, days int, length time.Duration) (string, time.Time, time.Time) {
+	start := storage.WallClock().Truncate(24*time.Hour).AddDate(0, 0, days).Add(10 * time.Hour)
+	end := start.Add(length)
+	body := fmt.Sprintf(`{"book_id":%d,"start_time":%q,"end_time":%q}`, bookID, start.Format(bookingLayout), end.Format(bookingLayout))
+	return body, start, end
+}
+
+func TestCreateBookingConflict(t *testing.T) {
+	lib := newTestLibrary()
+
+	body, _, end := window(duneID, 3, 48*time.Hour)
+	var created apiBooking
+	if rec := lib.apiRequest(t, "POST", "/api/v1/bookings", "ann", body, &created); rec.Code != http.StatusCreated {
+		t.Fatalf("first booking: got %d %s, want 201", rec.Code, rec.Body)
+	}
+	if created.UserID != annID || created.CopyID != 1 {
+		t.Errorf("first booking: got user %d copy %d, want user %d copy 1", created.UserID, created.CopyID, annID)
+	}
+
+	// Dune has a single copy, so an overlapping window is refused and the
+	// member is pointed at the end of Ann's booking.
+	overlapping, _, _ := window(duneID, 4, 24*time.Hour)
+	var refused apiError
+	if rec := lib.apiRequest(t, "POST", "/api/v1/bookings", "lee", overlapping, &refused); rec.Code != http.StatusConflict {
+		t.Fatalf("overlapping booking: got %d %s, want 409", rec.Code, rec.Body)
+	}
+	if want := "The next free slot is " + end.Format(displayLayout); !strings.Contains(refused.Error, want) {
+		t.Errorf("overlapping booking: got message %q, want it to contain %q", refused.Error, want)
+	}
+
+	after, _, _ := window(duneID, 5, 24*time.Hour)
+	if rec := lib.apiRequest(t, "POST", "/api/v1/bookings", "lee", after, nil); rec.Code != http.StatusCreated {
+		t.Fatalf("booking after the first: got %d %s, want 201", rec.Code, rec.Body)
+	}
+
+	if len(lib.outbox.mails) != 2 {
+		t.Fatalf("got %d mails, want a confirmation for each booking", len(lib.outbox.mails))
+	}
+	if mail := lib.outbox.mails[0]; mail.Recipient != "ann@example.com" || mail.Subject != "Booking Confirmed" {
+		t.Errorf("got mail %q to %s, want the confirmation to ann@example.com", mail.Subject, mail.Recipient)
+	}
+}
+
+func TestCreateBookingUsesEveryCopy(t *testing.T) {
+	lib := newTestLibrary()
+	body, _, _ := window(spqrID, 3, 24*time.Hour)
+
+	copies := map[int]bool{}
+	for _, token := range []string{"ann", "lee"} {
+		var created apiBooking
+		if rec := lib.apiRequest(t, "POST", "/api/v1/bookings", token, body, &created); rec.Code != http.StatusCreated {
+			t.Fatalf("booking by %s: got %d %s, want 201", token, rec.Code, rec.Body)
+		}
+		copies[created.CopyID] = true
+	}
+	if len(copies) != 2 {
+		t.Errorf("got copies %v, want both copies of SPQR booked", copies)
+	}
+
+	if rec := lib.apiRequest(t, "POST", "/api/v1/bookings", "ann", body, nil); rec.Code != http.StatusConflict {
+		t.Errorf("third booking: got %d %s, want 409", rec.Code, rec.Body)
+	}
+}
+
+func TestCreateBookingItemsOutLimit(t *testing.T) {
+	lib := newTestLibrary()
+	one := 1
+	lib.policies.policies = []storage.CirculationPolicy{{ID: 1, Role: storage.RoleMember, MaxItemsOut: &one}}
+
+	first, _, _ := window(duneID, 3, 24*time.Hour)
+	if rec := lib.apiRequest(t, "POST", "/api/v1/bookings", "ann", first, nil); rec.Code != http.StatusCreated {
+		t.Fatalf("first booking: got %d %s, want 201", rec.Code, rec.Body)
+	}
+	second, _, _ := window(spqrID, 3, 24*time.Hour)
+	if rec := lib.apiRequest(t, "POST", "/api/v1/bookings", "ann", second, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("second booking by a member: got %d %s, want 403", rec.Code, rec.Body)
+	}
+
+	// the policy is for members only
+	for i := 0; i < 2; i++ {
+		body, _, _ := window(spqrID, 10+i*2, 24*time.Hour)
+		if rec := lib.apiRequest(t, "POST", "/api/v1/bookings", "lee", body, nil); rec.Code != http.StatusCreated {
+			t.Errorf("booking %d by a librarian: got %d %s, want 201", i+1, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestRenewBooking(t *testing.T) {
+	lib := newTestLibrary()
+	body, _, end := window(duneID, 1, 24*time.Hour)
+	var booking apiBooking
+	if rec := lib.apiRequest(t, "POST", "/api/v1/bookings", "ann", body, &booking); rec.Code != http.StatusCreated {
+		t.Fatalf("booking: got %d %s, want 201", rec.Code, rec.Body)
+	}
+	renew := fmt.Sprintf("/api/v1/bookings/%d/renew", booking.ID)
+
+	for i := 1; i <= lib.cfg.Loans.MaxRenewals; i++ {
+		var renewed apiBooking
+		if rec := lib.apiRequest(t, "POST", renew, "ann", "", &renewed); rec.Code != http.StatusOK {
+			t.Fatalf("renewal %d: got %d %s, want 200", i, rec.Code, rec.Body)
+		}
+		wantEnd := end.AddDate(0, 0, i*lib.cfg.Loans.RenewalDays).Format(bookingLayout)
+		if renewed.EndTime != wantEnd || renewed.Renewals != i {
+			t.Errorf("renewal %d: got end %s after %d renewals, want %s", i, renewed.EndTime, renewed.Renewals, wantEnd)
+		}
+	}
+	if last := lib.outbox.mails[len(lib.outbox.mails)-1]; last.Subject != "Booking Renewed" {
+		t.Errorf("got last mail %q, want the renewal notice", last.Subject)
+	}
+
+	var refused apiError
+	if rec := lib.apiRequest(t, "POST", renew, "ann", "", &refused); rec.Code != http.StatusConflict {
+		t.Fatalf("renewal over the limit: got %d %s, want 409", rec.Code, rec.Body)
+	}
+	if refused.Error != renewMessages[storage.ErrRenewalLimit] {
+		t.Errorf("renewal over the limit: got message %q", refused.Error)
+	}
+}
+
+func TestRenewBookingPolicyAndConflicts(t *testing.T) {
+	lib := newTestLibrary()
+	none := 0
+	category := scienceFiction
+	lib.policies.policies = []storage.CirculationPolicy{{ID: 1, CategoryID: &category, Role: storage.RoleMember, MaxRenewals: &none}}
+
+	dune, _, _ := window(duneID, 1, 24*time.Hour)
+	var booking apiBooking
+	lib.apiRequest(t, "POST", "/api/v1/bookings", "ann", dune, &booking)
+	renew := fmt.Sprintf("/api/v1/bookings/%d/renew", booking.ID)
+	if rec := lib.apiRequest(t, "POST", renew, "ann", "", nil); rec.Code != http.StatusConflict {
+		t.Errorf("renewal a policy forbids: got %d %s, want 409", rec.Code, rec.Body)
+	}
+
+	// SPQR is in another category, so the default limit applies; the copy
+	// is taken right after the booking though.
+	spqr, _, _ := window(spqrID, 1, 24*time.Hour)
+	var first apiBooking
+	lib.apiRequest(t, "POST", "/api/v1/bookings", "ann", spqr, &first)
+	lib.bookings.copies[spqrID] = []int{first.CopyID}
+	next, _, _ := window(spqrID, 3, 24*time.Hour)
+	if rec := lib.apiRequest(t, "POST", "/api/v1/bookings", "lee", next, nil); rec.Code != http.StatusCreated {
+		t.Fatalf("following booking: got %d %s, want 201", rec.Code, rec.Body)
+	}
+	var refused apiError
+	renew = fmt.Sprintf("/api/v1/bookings/%d/renew", first.ID)
+	if rec := lib.apiRequest(t, "POST", renew, "ann", "", &refused); rec.Code != http.StatusConflict {
+		t.Fatalf("renewal into another booking: got %d %s, want 409", rec.Code, rec.Body)
+	}
+	if refused.Error != renewMessages[storage.ErrConflict] {
+		t.Errorf("renewal into another booking: got message %q", refused.Error)
+	}
+}
+
+func TestRenewSomeoneElsesBooking(t *testing.T) {
+	lib := newTestLibrary()
+	body, _, _ := window(duneID, 1, 24*time.Hour)
+	var booking apiBooking
+	lib.apiRequest(t, "POST", "/api/v1/bookings", "ann", body, &booking)
+
+	lib.users.users[3] = storage.User{ID: 3, Email: "max@example.com", Role: storage.RoleMember}
+	lib.users.tokens[hashToken("max")] = storage.APIToken{ID: 3, UserID: 3}
+	renew := fmt.Sprintf("/api/v1/bookings/%d/renew", booking.ID)
+	if rec := lib.apiRequest(t, "POST", renew, "max", "", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("renewal by another member: got %d %s, want 404", rec.Code, rec.Body)
+	}
+	// staff may renew for members
+	if rec := lib.apiRequest(t, "POST", renew, "lee", "", nil); rec.Code != http.StatusOK {
+		t.Errorf("renewal by a librarian: got %d %s, want 200", rec.Code, rec.Body)
+	}
+}
+
+func TestAPIBookingStatusTimes(t *testing.T) {
+	start := time.Date(2030, 1, 7, 10, 0, 0, 0, time.UTC)
+	checkedOut := start.Add(5 * time.Minute)
+	got := toAPIBooking(storage.Booking{StartTime: start, EndTime: start.Add(24 * time.Hour), Status: storage.BookingCheckedOut, CheckedOutAt: &checkedOut})
+	if got.CheckedOutAt != "2030-01-07T10:05" || got.StartTime != "2030-01-07T10:00" {
+		t.Errorf("got check-out %q and start %q, want both in the booking layout", got.CheckedOutAt, got.StartTime)
+	}
+	if got.ReturnedAt != "" || got.CancelledAt != "" {
+		t.Errorf("got return %q and cancellation %q, want them left out", got.ReturnedAt, got.CancelledAt)
+	}
+}
@@ -3,25 +3,18 @@ package handler
 import (
 	"fmt"
 	"io/ioutil"
-	"math"
 	"net/http"
 	"os"
-	"strconv"
+	"path/filepath"
+	"strings"
+
+	"library/pagination"
+	"library/storage"
 
 	validation "github.com/go-ozzo/ozzo-validation"
-	"github.com/gorilla/mux"
 )
 
-type Book struct {
-	ID int `db:"id"`
-	Category_id int `db:"category_id"`
-	Book_name string `db:"book_name"`
-	AuthorName string `db:"author_name"`
-	Details string `db:"details"`
-	Image string `db:"image"`
-	Status bool `db:"status"`
-	Cat_name string
-}
+type Book = storage.Book
 
 type FormBooks struct {
 	Book Book
@@ -30,25 +23,22 @@ type FormBooks struct {
 }
 
 type showBooks struct {
+	pagination.Page
 	Book	[]Book
 	Booking	[]Bookings
 	Category	[]Category
-	Offset	int
-	Limit	int
-	Total	int
-	Paginate	[]Pagination
-	CurrentPage	int
-	NextPageURL	string
-	PreviousPageURL	string
-	Search	string
+	Filter	storage.BookSearch
+	PageSizes	[]int
+	Access	Access
 }
 
-type Pagination struct {
-	URL	string
-	PageNumber	int
+type BookDetails struct {
+	Book	Book
+	Availability	Availability
+	Access	Access
 }
 
-func (b *Book) Validate() error {
+func validateBook(b *Book) error {
 	return validation.ValidateStruct(b, 
 		validation.Field(&b.Book_name, 
 			validation.Required.Error("This field is must be required"),
@@ -59,20 +49,29 @@ func (b *Book) Validate() error {
 		),
 		validation.Field(&b.Details,
 			validation.Required.Error("The Details Field is Required"),
+		),
+		validation.Field(&b.Category_id,
+			validation.Required.Error("Please choose a category"),
 		))
 }
 
 func (h *Handler) createBooks(rw http.ResponseWriter, r *http.Request) {
-	category := []Category{}
-	h.db.Select(&category, "SELECT * FROM categories")
+	category, err := h.categories.Categories(r.Context())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	vErrs := map[string]string{}
 	book := Book{}
 	h.loadCreateBookForm(rw, book, category, vErrs)
 }
 
 func (h *Handler) storeBooks(rw http.ResponseWriter, r *http.Request) {
-	category := []Category{}
-	h.db.Select(&category, "SELECT * FROM categories")
+	category, err := h.categories.Categories(r.Context())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	if err := r.ParseMultipartForm(10 << 20); err != nil {
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
@@ -99,7 +98,7 @@ func (h *Handler) storeBooks(rw http.ResponseWriter, r *http.Request) {
     }
     defer file.Close()
 
-    tempFile, err := ioutil.TempFile("assets/image", "upload-*.png")
+    tempFile, err := ioutil.TempFile(h.cfg.UploadDir, "upload-*.png")
     if err != nil {
         http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
@@ -115,7 +114,7 @@ func (h *Handler) storeBooks(rw http.ResponseWriter, r *http.Request) {
 	
 	imageName := tempFile.Name()
 
-	if err := book.Validate(); err != nil {
+	if err := validateBook(&book); err != nil {
 		vErrors, ok := err.(validation.Errors)
 		if ok {
 			vErrs := make(map[string]string)
@@ -129,9 +128,12 @@ func (h *Handler) storeBooks(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	const insertBook = `INSERT INTO books(category_id,book_name, author_name, details, image, status) VALUES($1, $2, $3, $4, $5, $6)`
-	res:= h.db.MustExec(insertBook, book.Category_id, book.Book_name, book.AuthorName, book.Details, imageName, book.Status)
-	if ok, err:= res.RowsAffected(); err != nil || ok == 0 {
+	book.Image = imageName
+	if err := h.books.CreateBook(r.Context(), &book); err != nil {
+		if err == storage.ErrInvalidReference {
+			h.loadCreateBookForm(rw, book, category, map[string]string{"Category_id": "Please choose a category"})
+			return
+		}
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -139,72 +141,32 @@ func (h *Handler) storeBooks(rw http.ResponseWriter, r *http.Request) {
 }
 
 func(h *Handler) listBooks(rw http.ResponseWriter, r *http.Request) {
-	// currentTime := time.Now()
-	// booking := []Bookings{}
-	// const getBooking = "SELECT * FROM bookings WHERE end_time < $1"
-	// h.db.Select(&booking, getBooking, currentTime)
-	// for _, value := range booking {
-	// 	const updateBook = "UPDATE books SET status = true WHERE id = $1"
-	// 	h.db.MustExec(updateBook, value.BookID)
-	// }
-	page := r.URL.Query().Get("page")
-	var p int = 1
-	var err error
-	if page != "" {
-		p, err = strconv.Atoi(page)
+	query := r.URL.Query()
+	var filter storage.BookSearch
+	if err := h.decoder.Decode(&filter, query); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
 	}
+	page := pagination.FromQuery(query, h.cfg.PageSize.Books, h.cfg.PageSize.BooksMax)
+	book, total, err := h.books.SearchBooks(r.Context(), filter, page.Offset, page.Limit)
 	if err != nil {
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	book := []Book{}
-	offset := 0
-	limit := 3
-	nextPageURL := ""
-	previousPageURL := ""
-	if p > 0 {
-		offset = limit * p - limit
-	}
-	total := 0
-	h.db.Get(&total, `SELECT count(*) FROM books`)
-	h.db.Select(&book, "SELECT * FROM books offset $1 limit $2", offset, limit)
-	for key, value := range book {
-		const getTodo = `SELECT name FROM categories WHERE id=$1`
-		var category Category
-		h.db.Get(&category, getTodo, value.Category_id)
-		book[key].Cat_name = category.Name
+	category, err := h.categories.Categories(r.Context())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	category := []Category{}
-	h.db.Select(&category, "SELECT * FROM categories")
-
-	totalPage := int(math.Ceil(float64(total)/float64(limit)))
-
-	paginate := make([]Pagination, totalPage)
-	for i := 0; i < totalPage; i++ {
-		paginate[i] = Pagination{
-			URL: fmt.Sprintf("http://localhost:3000/book/list?page=%d", i + 1),
-			PageNumber: i + 1,
-		}
-		if i + 1 == p {
-			if i != 0 {
-				previousPageURL = fmt.Sprintf("http://localhost:3000/book/list?page=%d", i)
-			}
-			if i + 1 != totalPage {
-				nextPageURL = fmt.Sprintf("http://localhost:3000/book/list?page=%d", i + 2)
-			}
-		}
-	}
+	page.Build(r.URL.Path, query, total)
 	list := showBooks{
+		Page: page,
 		Book : book,
 		Category: category,
-		Offset: offset,
-		Limit: limit,
-		Total: total,
-		Paginate: paginate,
-		CurrentPage: p,
-		NextPageURL: nextPageURL,
-		PreviousPageURL: previousPageURL,
+		Filter: filter,
+		PageSizes: h.bookPageSizes(),
+		Access: h.access(r),
 	}
 
 	if err:= h.templates.ExecuteTemplate(rw, "list-book.html", list); err != nil {
@@ -213,40 +175,38 @@ func(h *Handler) listBooks(rw http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// bookPageSizes are the page sizes offered on the book list.
+func (h *Handler) bookPageSizes() []int {
+	sizes := []int{h.cfg.PageSize.Books}
+	for _, n := range []int{10, 25, 50, 100} {
+		if n > h.cfg.PageSize.Books && n <= h.cfg.PageSize.BooksMax {
+			sizes = append(sizes, n)
+		}
+	}
+	return sizes
+}
+
 func (h *Handler) editBook(rw http.ResponseWriter, r *http.Request) {
-	category := []Category{}
-	h.db.Select(&category, "SELECT * FROM categories")
-	vars := mux.Vars(r)
-	id := vars["id"]
-	if id == "" {
-		http.Error(rw, "invalid URL", http.StatusInternalServerError)
+	category, err := h.categories.Categories(r.Context())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	const getBook = `SELECT * FROM books WHERE id=$1`
-	var book Book
-	h.db.Get(&book, getBook, id)
-	if book.ID == 0 {
-		http.Error(rw, "invalid URL", http.StatusInternalServerError)
+	book, ok := h.getBookFromURL(rw, r)
+	if !ok {
 		return
 	}
 	h.loadEditBookForm(rw, book, category, map[string]string{})
 }
 
 func (h *Handler) updateBook(rw http.ResponseWriter, r *http.Request) {
-	category := []Category{}
-	h.db.Select(&category, "SELECT * FROM categories")
-	vars := mux.Vars(r)
-	id := vars["id"]
-	if id == "" {
-		http.Error(rw, "invalid URL", http.StatusInternalServerError)
+	category, err := h.categories.Categories(r.Context())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	const getBook = `SELECT * FROM books WHERE id=$1`
-	var book Book
-	h.db.Get(&book, getBook, id)
-
-	if book.ID == 0 {
-		http.Error(rw, "invalid URL", http.StatusInternalServerError)
+	book, ok := h.getBookFromURL(rw, r)
+	if !ok {
 		return
 	}
 
@@ -255,10 +215,13 @@ func (h *Handler) updateBook(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// the image only changes by upload, never by a form value
+	storedImage := book.Image
 	if err := h.decoder.Decode(&book, r.PostForm); err != nil {
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	book.Image = storedImage
 
 	file, _, err := r.FormFile("Image")
     
@@ -266,7 +229,7 @@ func (h *Handler) updateBook(rw http.ResponseWriter, r *http.Request) {
 	
     if err == nil {
 		defer file.Close()
-		tempFile, err := ioutil.TempFile("assets/image", "upload-*.png")
+		tempFile, err := ioutil.TempFile(h.cfg.UploadDir, "upload-*.png")
 		if err != nil {
 			http.Error(rw, err.Error(), http.StatusInternalServerError)
 			return
@@ -283,15 +246,17 @@ func (h *Handler) updateBook(rw http.ResponseWriter, r *http.Request) {
 		
 		imageName = tempFile.Name()
 
-		if err := os.Remove(book.Image); err != nil {
+		if h.inUploadDir(book.Image) {
+			if err := os.Remove(book.Image); err != nil {
 				http.Error(rw, "Unable to upload image", http.StatusInternalServerError)
 				return
 			}
+		}
 	} else {
 		imageName = book.Image
 	}
 
-	if err := book.Validate(); err != nil {
+	if err := validateBook(&book); err != nil {
 		vErrors, ok := err.(validation.Errors)
 		if ok {
 			vErrs := make(map[string]string)
@@ -305,36 +270,46 @@ func (h *Handler) updateBook(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	const updateBook = `UPDATE books SET category_id = $2, book_name = $3, author_name = $4, details = $5, image = $6, status = $7 WHERE id = $1`
-	res:= h.db.MustExec(updateBook, id, book.Category_id, book.Book_name, book.AuthorName, book.Details, imageName, book.Status)
-	if ok, err:= res.RowsAffected(); err != nil || ok == 0 {
+	book.Image = imageName
+	if err := h.books.UpdateBook(r.Context(), book); err != nil {
+		if err == storage.ErrInvalidReference {
+			h.loadEditBookForm(rw, book, category, map[string]string{"Category_id": "Please choose a category"})
+			return
+		}
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	http.Redirect(rw, r, "/book/list", http.StatusTemporaryRedirect)
 }
 
-func (h *Handler) deleteBook(rw http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id := vars["id"]
-
-	if id == "" {
-		http.Error(rw, "Invalid URL", http.StatusInternalServerError)
-		return
+// inUploadDir reports whether path names a file inside the upload folder,
+// the only files the handlers may remove.
+func (h *Handler) inUploadDir(path string) bool {
+	rel, err := filepath.Rel(filepath.Clean(h.cfg.UploadDir), filepath.Clean(path))
+	if err != nil || rel == "." || rel == ".." {
+		return false
 	}
+	return !filepath.IsAbs(rel) && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
 
-	const getbook = "SELECT * FROM books WHERE id = $1"
-	var book Book
-	h.db.Get(&book, getbook, id)
-
-	if book.ID == 0 {
-		http.Error(rw, "Invalid URL", http.StatusInternalServerError)
+func (h *Handler) deleteBook(rw http.ResponseWriter, r *http.Request) {
+	book, ok := h.getBookFromURL(rw, r)
+	if !ok {
 		return
 	}
 
-	const deleteBook = `DELETE FROM books WHERE id = $1`
-	res:= h.db.MustExec(deleteBook, id)
-	if ok, err:= res.RowsAffected(); err != nil || ok == 0 {
+	// copies and finished bookings go with the book
+	if err := h.books.DeleteBook(r.Context(), book.ID); err != nil {
+		if err == storage.ErrActiveBookings {
+			h.loadDeleteBlocked(rw, DeleteBlocked{
+				Title: "Book cannot be deleted",
+				Message: fmt.Sprintf("%s still has reserved or checked out bookings. Check them in or cancel them before deleting the book.", book.Book_name),
+				BackURL: "/book/list",
+				LinkURL: fmt.Sprintf("/bookings?BookID=%d", book.ID),
+				LinkText: "View Bookings",
+			})
+			return
+		}
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -364,49 +339,3 @@ func (h *Handler) loadEditBookForm(rw http.ResponseWriter, book Book, cat []Cate
 		return
 	}
 }
-
-func (h *Handler) searchBook(rw http.ResponseWriter, r *http.Request) {
-	if err:= r.ParseForm(); err != nil {
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	search := r.FormValue("search")
-	const getSearch = "SELECT * FROM books WHERE book_name ILIKE '%%' || $1 || '%%'"
-	book := []Book{}
-	h.db.Select(&book, getSearch, search)
-	for key, value := range book {
-		const getTodo = `SELECT name FROM categories WHERE id=$1`
-		var category Category
-		h.db.Get(&category, getTodo, value.Category_id)
-		book[key].Cat_name = category.Name
-	}
-	list := showBooks{
-		Book : book,
-		Search: search,
-	}
-	if err:= h.templates.ExecuteTemplate(rw, "list-book.html", list); err != nil {
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
-		return
-	}
-}
-
-func (h *Handler) bookDetails(rw http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id := vars["id"]
-	if id == "" {
-		http.Error(rw, "invalid URL", http.StatusInternalServerError)
-		return
-	}
-	const getBook = `SELECT * FROM books WHERE id=$1`
-	var book Book
-	h.db.Get(&book, getBook, id)
-	const getTodo = `SELECT name FROM categories WHERE id=$1`
-	var category Category
-	h.db.Get(&category, getTodo, book.Category_id)
-	book.Cat_name = category.Name
-
-	if err:= h.templates.ExecuteTemplate(rw, "single-details.html", book); err != nil {
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
-		return
-	}
-}
\ No newline at end of file
@@ -1,20 +1,17 @@
 package handler
 
 import (
-	"fmt"
-	"math"
 	"net/http"
 	"strconv"
 
+	"library/pagination"
+	"library/storage"
+
 	validation "github.com/go-ozzo/ozzo-validation"
 	"github.com/gorilla/mux"
 )
 
-type Category struct {
-	ID int `db:"id"`
-	Name string `db:"name"`
-	Status bool `db:"status"`
-}
+type Category = storage.Category
 
 type FormCategory struct {
 	Cat Category
@@ -22,22 +19,21 @@ type FormCategory struct {
 }
 
 type ListCategory struct {
+	pagination.Page
 	Categories []Category
-	Offset	int
-	Limit	int
-	Total	int
-	Paginate	[]CategoryPagination
-	CurrentPage	int
-	NextPageURL string
-	PreviousPageURL	string
+	Access	Access
 }
 
-type CategoryPagination struct {
-	URL string
-	PageNumber	int
+// DeleteCategory asks where the books of a category should go before the
+// category can be deleted.
+type DeleteCategory struct {
+	Category	Category
+	BookCount	int
+	Categories	[]Category
+	Errors	map[string]string
 }
 
-func (c *Category) Validate() error {
+func validateCategory(c *Category) error {
 	return validation.ValidateStruct(c, validation.Field(
 		&c.Name, validation.Required.Error("This field is must be required"),
 		validation.Length(3,0).Error("This field is must be grater than 3"),
@@ -61,7 +57,7 @@ func (h *Handler) storeCategories(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := category.Validate(); err != nil {
+	if err := validateCategory(&category); err != nil {
 		vErrors, ok := err.(validation.Errors)
 		if ok {
 			vErrs := make(map[string]string)
@@ -74,11 +70,8 @@ func (h *Handler) storeCategories(rw http.ResponseWriter, r *http.Request) {
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	
-	const insertCategory = `INSERT INTO categories(name,status) VALUES($1,$2)`
-	res:= h.db.MustExec(insertCategory, category.Name, category.Status )
 
-	if ok, err:= res.RowsAffected(); err != nil || ok == 0 {
+	if err := h.categories.CreateCategory(r.Context(), &category); err != nil {
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -86,54 +79,19 @@ func (h *Handler) storeCategories(rw http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) listCategories(rw http.ResponseWriter, r *http.Request) {
-	page := r.URL.Query().Get("page")
-	var p int = 1
-	var err error
-	if page != "" {
-		p, err = strconv.Atoi(page)
-	}
+	query := r.URL.Query()
+	page := pagination.FromQuery(query, h.cfg.PageSize.Categories, h.cfg.PageSize.Categories)
+	category, total, err := h.categories.PageCategories(r.Context(), page.Offset, page.Limit)
 	if err != nil {
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	category := []Category{}
-	offset := 0
-	limit := 3
-	if p > 0 {
-		offset = limit * p - limit
-	}
-	total := 0
-	nextPageURL := ""
-	previousPageURL := ""
-	h.db.Get(&total, `SELECT count(*) FROM categories`)
-	h.db.Select(&category, "SELECT * FROM categories offset $1 limit $2", offset, limit)
-
-	totalPage := int(math.Ceil(float64(total)/float64(limit)))
 
-	paginate := make([]CategoryPagination, totalPage)
-	for i := 0; i < totalPage; i++ {
-		paginate[i] = CategoryPagination{
-			URL: fmt.Sprintf("http://localhost:3000/category/list?page=%d", i + 1),
-			PageNumber: i + 1,
-		}
-		if i + 1 == p {
-			if i != 0 {
-				previousPageURL = fmt.Sprintf("http://localhost:3000/category/list?page=%d", i)
-			}
-			if i + 1 != totalPage {
-				nextPageURL = fmt.Sprintf("http://localhost:3000/book/list?page=%d", i + 2)
-			}
-		}
-	}
+	page.Build(r.URL.Path, query, total)
 	list := ListCategory{
+		Page: page,
 		Categories: category,
-		Offset: offset,
-		Limit: limit,
-		Total: total,
-		Paginate: paginate,
-		CurrentPage: p,
-		NextPageURL: nextPageURL,
-		PreviousPageURL: previousPageURL,
+		Access: h.access(r),
 	}
 	if err:= h.templates.ExecuteTemplate(rw, "list-category.html", list); err != nil {
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
@@ -142,38 +100,16 @@ func (h *Handler) listCategories(rw http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) editCategories(rw http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id := vars["id"]
-	if id == "" {
-		http.Error(rw, "invalid URL", http.StatusInternalServerError)
-		return
-	}
-	const getCategory = `SELECT * FROM categories WHERE id=$1`
-	var category Category
-	h.db.Get(&category, getCategory, id)
-	
-	if category.ID == 0 {
-		http.Error(rw, "invalid URL", http.StatusInternalServerError)
+	category, ok := h.getCategoryFromURL(rw, r)
+	if !ok {
 		return
 	}
 	h.loadEditCategoryForm(rw, category, map[string]string{})
 }
 
 func (h *Handler) updateCategories(rw http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id := vars["id"]
-
-	if id == "" {
-		http.Error(rw, "invalid URL", http.StatusInternalServerError)
-		return
-	}
-
-	const getCategory = `SELECT * FROM categories WHERE id = $1`
-	var category Category
-	h.db.Get(&category, getCategory, id)
-
-	if category.ID == 0 {
-		http.Error(rw, "invalid URL", http.StatusInternalServerError)
+	category, ok := h.getCategoryFromURL(rw, r)
+	if !ok {
 		return
 	}
 
@@ -181,28 +117,25 @@ func (h *Handler) updateCategories(rw http.ResponseWriter, r *http.Request) {
 		http.Error(rw, "invalid URL", http.StatusInternalServerError)
 		return
 	}
-	var cat Category
 	if err := h.decoder.Decode(&category, r.PostForm); err != nil {
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if err := cat.Validate(); err != nil {
+	if err := validateCategory(&category); err != nil {
 		vErrors, ok := err.(validation.Errors)
 		if ok {
 			vErrs := make(map[string]string)
 			for key, value := range vErrors {
 				vErrs[key] = value.Error()
 			}
-			h.loadEditCategoryForm(rw, cat, vErrs)
+			h.loadEditCategoryForm(rw, category, vErrs)
 			return
 		}
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	const updateCategories = `UPDATE categories SET name = $2, status = $3 WHERE id = $1`
-	res:= h.db.MustExec(updateCategories, id, cat.Name, cat.Status )
-	if ok, err := res.RowsAffected(); err != nil || ok == 0 {
+	if err := h.categories.UpdateCategory(r.Context(), category); err != nil {
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -210,30 +143,93 @@ func (h *Handler) updateCategories(rw http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) deleteCategories(rw http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id := vars["id"]
+	category, ok := h.getCategoryFromURL(rw, r)
+	if !ok {
+		return
+	}
 
-	if id == "" {
-		http.Error(rw, "invalid URL", http.StatusInternalServerError)
+	books, err := h.categories.CountBooks(r.Context(), category.ID)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if books > 0 && r.Method != http.MethodPost {
+		h.loadDeleteCategoryForm(rw, r, category, map[string]string{})
 		return
 	}
 
-	const getCategory = `SELECT * FROM categories WHERE id = $1`
-	var category Category
-	h.db.Get(&category, getCategory, id)
+	target := 0
+	if books > 0 {
+		if err := r.ParseForm(); err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		target, _ = strconv.Atoi(r.FormValue("ReassignTo"))
+		if _, err := h.categories.Category(r.Context(), target); target == category.ID || err != nil {
+			h.loadDeleteCategoryForm(rw, r, category, map[string]string{"ReassignTo": "Please choose the category the books should move to"})
+			return
+		}
+	}
 
-	if category.ID == 0 {
-		http.Error(rw, "invalid URL", http.StatusInternalServerError)
+	if err := h.categories.DeleteCategory(r.Context(), category.ID, target); err != nil {
+		switch err {
+		case storage.ErrInvalidReference:
+			h.loadDeleteCategoryForm(rw, r, category, map[string]string{"ReassignTo": "Please choose the category the books should move to"})
+			return
+		case storage.ErrInUse:
+			// a book was added to the category in the meantime
+			h.loadDeleteCategoryForm(rw, r, category, map[string]string{"ReassignTo": "New books were added to this category. Please choose again."})
+			return
+		}
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	http.Redirect(rw, r, "/category/list", http.StatusSeeOther)
+}
 
-	const deleteCategories = `DELETE FROM categories WHERE id = $1`
-	res:= h.db.MustExec(deleteCategories, id)
-	if ok, err:= res.RowsAffected(); err != nil || ok == 0 {
+func (h *Handler) getCategoryFromURL(rw http.ResponseWriter, r *http.Request) (Category, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
 		http.Error(rw, "invalid URL", http.StatusInternalServerError)
+		return Category{}, false
+	}
+	category, err := h.categories.Category(r.Context(), id)
+	if err == storage.ErrNotFound {
+		http.Error(rw, "invalid URL", http.StatusInternalServerError)
+		return category, false
+	}
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return category, false
+	}
+	return category, true
+}
+
+func (h *Handler) loadDeleteCategoryForm(rw http.ResponseWriter, r *http.Request, category Category, errs map[string]string) {
+	form := DeleteCategory{
+		Category: category,
+		Categories: []Category{},
+		Errors: errs,
+	}
+	var err error
+	if form.BookCount, err = h.categories.CountBooks(r.Context(), category.ID); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	categories, err := h.categories.Categories(r.Context())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, c := range categories {
+		if c.ID != category.ID {
+			form.Categories = append(form.Categories, c)
+		}
+	}
+	if err:= h.templates.ExecuteTemplate(rw, "delete-category.html", form); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	http.Redirect(rw, r, "/category/list", http.StatusTemporaryRedirect)
 }
 
 func (h *Handler) loadCreateCategoryForm(rw http.ResponseWriter, cat Category, errs map[string]string) {
@@ -264,11 +260,14 @@ func (h *Handler) searchCategory(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 	cat := r.FormValue("search")
-	const getSearch = "SELECT * FROM categories WHERE name ILIKE '%%' || $1 || '%%'" 
-	category := []Category{}
-	h.db.Select(&category, getSearch, cat)
+	category, err := h.categories.SearchCategories(r.Context(), cat)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	list := ListCategory{
 		Categories: category,
+		Access: h.access(r),
 	}
 	if err:= h.templates.ExecuteTemplate(rw, "list-category.html", list); err != nil {
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
@@ -0,0 +1,247 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"library/storage"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/gorilla/mux"
+)
+
+type BookCopy = storage.BookCopy
+
+type FormCopy struct {
+	Book   Book
+	Copy   BookCopy
+	Errors map[string]string
+}
+
+type ListCopies struct {
+	Book   Book
+	Copies []BookCopy
+}
+
+var copyConditions = []interface{}{"new", "good", "fair", "poor", "damaged"}
+
+func validateCopy(c *BookCopy) error {
+	return validation.ValidateStruct(c,
+		validation.Field(&c.Barcode,
+			validation.Required.Error("The Barcode Field is Required"),
+		),
+		validation.Field(&c.Condition,
+			validation.Required.Error("The Condition Field is Required"),
+			validation.In(copyConditions...).Error("The Condition is not valid"),
+		),
+		validation.Field(&c.ShelfLocation,
+			validation.Required.Error("The Shelf Location Field is Required"),
+		),
+	)
+}
+
+func (h *Handler) listCopies(rw http.ResponseWriter, r *http.Request) {
+	book, ok := h.getBookFromURL(rw, r)
+	if !ok {
+		return
+	}
+	copies, err := h.books.Copies(r.Context(), book.ID)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	list := ListCopies{
+		Book:   book,
+		Copies: copies,
+	}
+	if err := h.templates.ExecuteTemplate(rw, "list-copies.html", list); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *Handler) createCopies(rw http.ResponseWriter, r *http.Request) {
+	book, ok := h.getBookFromURL(rw, r)
+	if !ok {
+		return
+	}
+	h.loadCopyForm(rw, "create-copy.html", book, BookCopy{Status: true}, map[string]string{})
+}
+
+func (h *Handler) storeCopies(rw http.ResponseWriter, r *http.Request) {
+	book, ok := h.getBookFromURL(rw, r)
+	if !ok {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var bookCopy BookCopy
+	if err := h.decoder.Decode(&bookCopy, r.PostForm); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := validateCopy(&bookCopy); err != nil {
+		vErrors, ok := err.(validation.Errors)
+		if ok {
+			vErrs := make(map[string]string)
+			for key, value := range vErrors {
+				vErrs[key] = value.Error()
+			}
+			h.loadCopyForm(rw, "create-copy.html", book, bookCopy, vErrs)
+			return
+		}
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	taken, err := h.books.BarcodeTaken(r.Context(), bookCopy.Barcode, 0)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if taken {
+		h.loadCopyForm(rw, "create-copy.html", book, bookCopy, map[string]string{"Barcode": "The Barcode is already in use"})
+		return
+	}
+
+	bookCopy.BookID = book.ID
+	if err := h.books.CreateCopy(r.Context(), &bookCopy); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(rw, r, fmt.Sprintf("/book/%d/copies", book.ID), http.StatusTemporaryRedirect)
+}
+
+func (h *Handler) editCopy(rw http.ResponseWriter, r *http.Request) {
+	bookCopy, ok := h.getCopyFromURL(rw, r)
+	if !ok {
+		return
+	}
+	book, err := h.books.Book(r.Context(), bookCopy.BookID)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.loadCopyForm(rw, "edit-copy.html", book, bookCopy, map[string]string{})
+}
+
+func (h *Handler) updateCopy(rw http.ResponseWriter, r *http.Request) {
+	bookCopy, ok := h.getCopyFromURL(rw, r)
+	if !ok {
+		return
+	}
+	book, err := h.books.Book(r.Context(), bookCopy.BookID)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := h.decoder.Decode(&bookCopy, r.PostForm); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := validateCopy(&bookCopy); err != nil {
+		vErrors, ok := err.(validation.Errors)
+		if ok {
+			vErrs := make(map[string]string)
+			for key, value := range vErrors {
+				vErrs[key] = value.Error()
+			}
+			h.loadCopyForm(rw, "edit-copy.html", book, bookCopy, vErrs)
+			return
+		}
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	taken, err := h.books.BarcodeTaken(r.Context(), bookCopy.Barcode, bookCopy.ID)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if taken {
+		h.loadCopyForm(rw, "edit-copy.html", book, bookCopy, map[string]string{"Barcode": "The Barcode is already in use"})
+		return
+	}
+
+	if err := h.books.UpdateCopy(r.Context(), bookCopy); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(rw, r, fmt.Sprintf("/book/%d/copies", bookCopy.BookID), http.StatusTemporaryRedirect)
+}
+
+func (h *Handler) deleteCopy(rw http.ResponseWriter, r *http.Request) {
+	bookCopy, ok := h.getCopyFromURL(rw, r)
+	if !ok {
+		return
+	}
+	if err := h.books.DeleteCopy(r.Context(), bookCopy.ID); err != nil {
+		if err == storage.ErrActiveBookings {
+			h.loadDeleteBlocked(rw, DeleteBlocked{
+				Title: "Copy cannot be deleted",
+				Message: fmt.Sprintf("Copy %s is reserved or checked out. Check it in or cancel the booking before deleting the copy.", bookCopy.Barcode),
+				BackURL: fmt.Sprintf("/book/%d/copies", bookCopy.BookID),
+				LinkURL: fmt.Sprintf("/bookings?BookID=%d", bookCopy.BookID),
+				LinkText: "View Bookings",
+			})
+			return
+		}
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(rw, r, fmt.Sprintf("/book/%d/copies", bookCopy.BookID), http.StatusTemporaryRedirect)
+}
+
+func (h *Handler) getBookFromURL(rw http.ResponseWriter, r *http.Request) (Book, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(rw, "invalid URL", http.StatusInternalServerError)
+		return Book{}, false
+	}
+	book, err := h.books.Book(r.Context(), id)
+	if err == storage.ErrNotFound {
+		http.Error(rw, "invalid URL", http.StatusInternalServerError)
+		return book, false
+	}
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return book, false
+	}
+	return book, true
+}
+
+func (h *Handler) getCopyFromURL(rw http.ResponseWriter, r *http.Request) (BookCopy, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(rw, "invalid URL", http.StatusInternalServerError)
+		return BookCopy{}, false
+	}
+	bookCopy, err := h.books.Copy(r.Context(), id)
+	if err == storage.ErrNotFound {
+		http.Error(rw, "invalid URL", http.StatusInternalServerError)
+		return bookCopy, false
+	}
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return bookCopy, false
+	}
+	return bookCopy, true
+}
+
+func (h *Handler) loadCopyForm(rw http.ResponseWriter, tmpl string, book Book, bookCopy BookCopy, errs map[string]string) {
+	form := FormCopy{
+		Book:   book,
+		Copy:   bookCopy,
+		Errors: errs,
+	}
+	if err := h.templates.ExecuteTemplate(rw, tmpl, form); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
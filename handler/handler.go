@@ -2,38 +2,65 @@ package handler
 
 import (
 	
-	"log"
 	"net/http"
-	"text/template"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"html/template"
+
+	"library/config"
+	"library/storage"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/schema"
 	"github.com/gorilla/sessions"
-	"github.com/jmoiron/sqlx"
 )
 
 const sessionName = "library-session"
 
 type Handler struct {
 	templates *template.Template
-	db 	*sqlx.DB
 	decoder *schema.Decoder
 	sess *sessions.CookieStore
+	cfg config.Config
+	books storage.BookStore
+	categories storage.CategoryStore
+	bookings storage.BookingStore
+	users storage.UserStore
+	outbox storage.OutboxStore
+	holds storage.HoldStore
+	ledger storage.LedgerStore
+	policies storage.PolicyStore
 }
 
-func New(db *sqlx.DB, decoder *schema.Decoder, sess *sessions.CookieStore) *mux.Router {
+func New(stores storage.Stores, decoder *schema.Decoder, sess *sessions.CookieStore, cfg config.Config) *mux.Router {
 	h:= &Handler{
-		db: db,
 		decoder: decoder,
 		sess: sess,
+		cfg: cfg,
+		books: stores.Books,
+		categories: stores.Categories,
+		bookings: stores.Bookings,
+		users: stores.Users,
+		outbox: stores.Outbox,
+		holds: stores.Holds,
+		ledger: stores.Ledger,
+		policies: stores.Policies,
 	}
 
 	h.parseTemplate()
 
 	r:= mux.NewRouter()
+	r.Use(h.tokenMiddleware)
 	r.HandleFunc("/", h.home)
 	r.HandleFunc("/logout", h.logout)
-	r.HandleFunc("/resetpassword", h.forgotPassword)
+	r.HandleFunc("/resetpassword", h.forgotPassword).Methods("GET")
+	r.HandleFunc("/resetpassword", h.sendResetLink).Methods("POST")
+	r.HandleFunc("/resetpassword/{token}", h.newPassword).Methods("GET")
+	r.HandleFunc("/resetpassword/{token}", h.storeNewPassword).Methods("POST")
+	r.HandleFunc("/verify", h.verifyEmail).Methods("GET")
+	r.HandleFunc("/verify/resend", h.resendVerification).Methods("GET")
+	r.HandleFunc("/verify/resend", h.storeResendVerification).Methods("POST")
 
 	l := r.NewRoute().Subrouter()
 	l.HandleFunc("/registration", h.signUp).Methods("GET")
@@ -44,26 +71,71 @@ func New(db *sqlx.DB, decoder *schema.Decoder, sess *sessions.CookieStore) *mux.
 
 	s := r.NewRoute().Subrouter()
 	s.Use(h.authMiddleware)
-	s.HandleFunc("/category/create", h.createCategories)
-	s.HandleFunc("/category/store", h.storeCategories)
 	s.HandleFunc("/category/list", h.listCategories)
-	s.HandleFunc("/category/{id:[0-9]+}/edit", h.editCategories)
-	s.HandleFunc("/category/{id:[0-9]+}/update", h.updateCategories)
-	s.HandleFunc("/category/{id:[0-9]+}/delete", h.deleteCategories)
 	s.HandleFunc("/category/search", h.searchCategory)
-	s.HandleFunc("/book/create", h.createBooks)
-	s.HandleFunc("/book/store", h.storeBooks)
 	s.HandleFunc("/book/list", h.listBooks)
-	s.HandleFunc("/book/{id:[0-9]+}/edit", h.editBook)
-	s.HandleFunc("/book/{id:[0-9]+}/update", h.updateBook)
-	s.HandleFunc("/book/{id:[0-9]+}/delete", h.deleteBook)
-	s.HandleFunc("/book/search", h.searchBook)
+	s.HandleFunc("/book/search", h.listBooks)
+	s.HandleFunc("/book/{id:[0-9]+}/bookdetails", h.bookDetails)
 	s.HandleFunc("/bookings/{id:[0-9]+}/create", h.createBookings)
 	s.HandleFunc("/bookings/store", h.storeBookings)
+	s.HandleFunc("/bookings/{id:[0-9]+}/cancel", h.cancelBooking).Methods("POST")
+	s.HandleFunc("/bookings/{id:[0-9]+}/renew", h.renewBooking).Methods("POST")
 	s.HandleFunc("/mybookings", h.myBookings)
-	s.HandleFunc("/book/{id:[0-9]+}/bookdetails", h.bookDetails)
-	s.PathPrefix("/asset/").Handler(http.StripPrefix("/asset/", http.FileServer(http.Dir("./"))))
-	
+	s.HandleFunc("/book/{id:[0-9]+}/waitlist", h.joinWaitlist).Methods("POST")
+	s.HandleFunc("/holds/{id:[0-9]+}/cancel", h.cancelHold).Methods("POST")
+	s.HandleFunc("/account", h.myAccount).Methods("GET")
+	s.HandleFunc("/profile", h.profile).Methods("GET")
+	s.HandleFunc("/profile/tokens", h.storeToken).Methods("POST")
+	s.HandleFunc("/profile/tokens/{id:[0-9]+}/revoke", h.revokeToken).Methods("POST")
+	// book images are stored as paths under the upload folder, so only that
+	// folder is served, at the same path under /asset/
+	uploads := "/asset/" + filepath.ToSlash(filepath.Clean(cfg.UploadDir)) + "/"
+	s.PathPrefix(uploads).Handler(http.StripPrefix(uploads, noDirListing(http.FileServer(http.Dir(cfg.UploadDir)))))
+
+	c := s.NewRoute().Subrouter()
+	c.Use(h.permissionMiddleware(permManageCatalog))
+	c.HandleFunc("/category/create", h.createCategories)
+	c.HandleFunc("/category/store", h.storeCategories)
+	c.HandleFunc("/category/{id:[0-9]+}/edit", h.editCategories)
+	c.HandleFunc("/category/{id:[0-9]+}/update", h.updateCategories)
+	c.HandleFunc("/category/{id:[0-9]+}/delete", h.deleteCategories)
+	c.HandleFunc("/book/create", h.createBooks)
+	c.HandleFunc("/book/store", h.storeBooks)
+	c.HandleFunc("/book/{id:[0-9]+}/edit", h.editBook)
+	c.HandleFunc("/book/{id:[0-9]+}/update", h.updateBook)
+	c.HandleFunc("/book/{id:[0-9]+}/delete", h.deleteBook)
+	c.HandleFunc("/book/{id:[0-9]+}/copies", h.listCopies)
+	c.HandleFunc("/book/{id:[0-9]+}/copies/create", h.createCopies)
+	c.HandleFunc("/book/{id:[0-9]+}/copies/store", h.storeCopies)
+	c.HandleFunc("/copies/{id:[0-9]+}/edit", h.editCopy)
+	c.HandleFunc("/copies/{id:[0-9]+}/update", h.updateCopy)
+	c.HandleFunc("/copies/{id:[0-9]+}/delete", h.deleteCopy)
+
+	b := s.NewRoute().Subrouter()
+	b.Use(h.permissionMiddleware(permManageBookings))
+	b.HandleFunc("/bookings", h.allBookings)
+	b.HandleFunc("/bookings/{id:[0-9]+}/checkout", h.checkoutBooking).Methods("POST")
+	b.HandleFunc("/bookings/{id:[0-9]+}/checkin", h.checkinBooking).Methods("POST")
+	b.HandleFunc("/users/{id:[0-9]+}/account", h.userAccount).Methods("GET")
+	b.HandleFunc("/users/{id:[0-9]+}/account", h.storeLedgerEntry).Methods("POST")
+
+	a := s.NewRoute().Subrouter()
+	a.Use(h.permissionMiddleware(permManageUsers))
+	a.HandleFunc("/users", h.listUsers)
+	a.HandleFunc("/users/{id:[0-9]+}/role", h.updateUserRole).Methods("POST")
+	a.HandleFunc("/outbox", h.listOutbox)
+	a.HandleFunc("/outbox/{id:[0-9]+}/retry", h.retryOutbox).Methods("POST")
+
+	p := s.NewRoute().Subrouter()
+	p.Use(h.permissionMiddleware(permManagePolicies))
+	p.HandleFunc("/policies", h.listPolicies).Methods("GET")
+	p.HandleFunc("/policies/create", h.createPolicy).Methods("GET")
+	p.HandleFunc("/policies/store", h.storePolicy).Methods("POST")
+	p.HandleFunc("/policies/{id:[0-9]+}/edit", h.editPolicy).Methods("GET")
+	p.HandleFunc("/policies/{id:[0-9]+}/update", h.updatePolicy).Methods("POST")
+	p.HandleFunc("/policies/{id:[0-9]+}/delete", h.deletePolicy).Methods("POST")
+
+	h.registerAPI(r)
 
 	r.NotFoundHandler = http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
 		if err := h.templates.ExecuteTemplate(rw, "404.html", nil); err != nil {
@@ -87,22 +159,38 @@ func (h *Handler) parseTemplate() {
 		"templates/home.html",
 		"templates/bookings/create-bookings.html",
 		"templates/bookings/my-bookings.html",
+		"templates/bookings/all-bookings.html",
 		"templates/book/single-details.html",
+		"templates/copies/list-copies.html",
+		"templates/copies/create-copy.html",
+		"templates/copies/edit-copy.html",
 		"templates/signup.html",
 		"templates/login.html",
 		"templates/reset-password.html",
+		"templates/new-password.html",
+		"templates/resend-verification.html",
+		"templates/users/list-users.html",
+		"templates/users/profile.html",
+		"templates/outbox/list-outbox.html",
+		"templates/category/delete-category.html",
+		"templates/delete-blocked.html",
+		"templates/pagination.html",
+		"templates/users/account.html",
+		"templates/policies/list-policies.html",
+		"templates/policies/policy-form.html",
 		))
 }
 
 func (h *Handler) authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-		session, err := h.sess.Get(r, sessionName)
-		if err != nil {
-			log.Fatal(err)
-		}
-		authUserID := session.Values["authUserID"]
-		if authUserID != nil {
+		if _, ok := r.Context().Value(accessKey{}).(Access); ok {
+			// already authenticated by tokenMiddleware
 			next.ServeHTTP(rw, r)
+			return
+		}
+		access, ok := h.sessionAccess(r)
+		if ok {
+			next.ServeHTTP(rw, withAccess(r, access))
 		} else {
 			http.Redirect(rw, r, "/login", http.StatusTemporaryRedirect)
 		}
@@ -110,18 +198,71 @@ func (h *Handler) authMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-func (h *Handler) loginMiddleware(next http.Handler) http.Handler {
+// sessionAccess reads the logged in user from the session cookie. Sessions
+// carry the user's session_version from login time, so bumping the version
+// (for example after a password reset) logs out every existing session.
+func (h *Handler) sessionAccess(r *http.Request) (Access, bool) {
+	session, err := h.sess.Get(r, sessionName)
+	if err != nil {
+		return Access{}, false
+	}
+	id, ok := session.Values["authUserID"].(int)
+	if !ok {
+		return Access{}, false
+	}
+	version, _ := session.Values["sessionVersion"].(int)
+	user, err := h.users.User(r.Context(), id)
+	if err != nil || user.SessionVersion != version {
+		return Access{}, false
+	}
+	return Access{UserID: user.ID, Role: user.Role}, true
+}
+
+// redirectBack sends the user to the page they came from on this site, or to
+// fallback when the referer is missing.
+func redirectBack(rw http.ResponseWriter, r *http.Request, fallback string) {
+	target := fallback
+	if ref, err := url.Parse(r.Referer()); err == nil && ref.Path != "" && (ref.Host == "" || ref.Host == r.Host) {
+		target = ref.RequestURI()
+	}
+	http.Redirect(rw, r, target, http.StatusSeeOther)
+}
+
+// noDirListing answers 404 for folders instead of listing their files.
+func noDirListing(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-		session, err := h.sess.Get(r, sessionName)
-		if err != nil {
-			log.Fatal(err)
+		if r.URL.Path == "" || strings.HasSuffix(r.URL.Path, "/") {
+			http.NotFound(rw, r)
+			return
 		}
-		authUserID := session.Values["authUserID"]
-		if authUserID != nil {
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// DeleteBlocked explains why a delete was refused.
+type DeleteBlocked struct {
+	Title    string
+	Message  string
+	BackURL  string
+	LinkURL  string
+	LinkText string
+}
+
+func (h *Handler) loadDeleteBlocked(rw http.ResponseWriter, data DeleteBlocked) {
+	rw.WriteHeader(http.StatusConflict)
+	if err := h.templates.ExecuteTemplate(rw, "delete-blocked.html", data); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *Handler) loginMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if _, ok := h.sessionAccess(r); ok {
 			http.Redirect(rw, r, "/", http.StatusTemporaryRedirect)
 			return
 		} else {
 			next.ServeHTTP(rw, r)
 		}
 	})
-}
\ No newline at end of file
+}
@@ -0,0 +1,392 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"library/config"
+	"library/storage"
+
+	"github.com/gorilla/schema"
+	"github.com/gorilla/sessions"
+)
+
+// The handlers read their templates from paths relative to the repository
+// root, so the tests run from there.
+func TestMain(m *testing.M) {
+	if err := os.Chdir(".."); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// The fakes embed the store interfaces they stand in for and implement only
+// the methods the tested handlers call. Any other call panics, which points
+// straight at the method a new test has to fake.
+
+type fakeBooks struct {
+	storage.BookStore
+	books map[int]storage.Book
+}
+
+func (f *fakeBooks) Book(ctx context.Context, id int) (storage.Book, error) {
+	book, ok := f.books[id]
+	if !ok {
+		return book, storage.ErrNotFound
+	}
+	return book, nil
+}
+
+type fakeUsers struct {
+	storage.UserStore
+	users  map[int]storage.User
+	tokens map[string]storage.APIToken
+	resets []storage.PasswordReset
+}
+
+func (f *fakeUsers) User(ctx context.Context, id int) (storage.User, error) {
+	user, ok := f.users[id]
+	if !ok {
+		return user, storage.ErrNotFound
+	}
+	return user, nil
+}
+
+func (f *fakeUsers) UserByEmail(ctx context.Context, email string) (storage.User, error) {
+	for _, user := range f.users {
+		if strings.EqualFold(user.Email, email) {
+			return user, nil
+		}
+	}
+	return storage.User{}, storage.ErrNotFound
+}
+
+// CreateUser refuses emails that are taken in any case, like the unique
+// index on users.
+func (f *fakeUsers) CreateUser(ctx context.Context, user *storage.User) error {
+	for _, other := range f.users {
+		if strings.EqualFold(other.Email, user.Email) {
+			return storage.ErrEmailTaken
+		}
+	}
+	user.ID = len(f.users) + 1
+	user.Role = storage.RoleMember
+	f.users[user.ID] = *user
+	return nil
+}
+
+func (f *fakeUsers) APITokenByHash(ctx context.Context, hash string) (storage.APIToken, error) {
+	token, ok := f.tokens[hash]
+	if !ok || token.RevokedAt != nil {
+		return token, storage.ErrNotFound
+	}
+	return token, nil
+}
+
+func (f *fakeUsers) CreatePasswordReset(ctx context.Context, reset *storage.PasswordReset) error {
+	reset.ID = len(f.resets) + 1
+	reset.CreatedAt = time.Now()
+	f.resets = append(f.resets, *reset)
+	return nil
+}
+
+func (f *fakeUsers) PasswordReset(ctx context.Context, hash string) (storage.PasswordReset, error) {
+	for _, reset := range f.resets {
+		if reset.TokenHash == hash && reset.UsedAt == nil && reset.ExpiresAt.After(time.Now()) {
+			return reset, nil
+		}
+	}
+	return storage.PasswordReset{}, storage.ErrNotFound
+}
+
+func (f *fakeUsers) ResetPassword(ctx context.Context, resetID int, passwordHash string) error {
+	for i, reset := range f.resets {
+		if reset.ID != resetID || reset.UsedAt != nil {
+			continue
+		}
+		now := time.Now()
+		f.resets[i].UsedAt = &now
+		user := f.users[reset.UserID]
+		user.Password = passwordHash
+		user.SessionVersion++
+		f.users[user.ID] = user
+		for hash, token := range f.tokens {
+			if token.UserID == user.ID && token.RevokedAt == nil {
+				token.RevokedAt = &now
+				f.tokens[hash] = token
+			}
+		}
+		return nil
+	}
+	return storage.ErrNotFound
+}
+
+// fakeBookings books copies like the SQL store: a reservation takes the
+// first copy of the title that has no active booking overlapping it.
+type fakeBookings struct {
+	storage.BookingStore
+	copies   map[int][]int
+	bookings []storage.Booking
+}
+
+func (f *fakeBookings) active(b storage.Booking) bool {
+	return b.Status == storage.BookingReserved || b.Status == storage.BookingCheckedOut
+}
+
+func (f *fakeBookings) Reserve(ctx context.Context, userID, bookID int, start, end time.Time, maxItemsOut int) (int, error) {
+	if maxItemsOut > 0 {
+		out := 0
+		for _, b := range f.bookings {
+			if b.UserID == userID && f.active(b) {
+				out++
+			}
+		}
+		if out >= maxItemsOut {
+			return 0, storage.ErrItemsOutLimit
+		}
+	}
+	for _, copyID := range f.copies[bookID] {
+		if f.overlaps(copyID, 0, start, end) {
+			continue
+		}
+		id := len(f.bookings) + 1
+		f.bookings = append(f.bookings, storage.Booking{ID: id, UserID: userID, BookID: bookID, CopyID: copyID, StartTime: start, EndTime: end, Status: storage.BookingReserved})
+		return id, nil
+	}
+	return 0, storage.ErrConflict
+}
+
+func (f *fakeBookings) overlaps(copyID, except int, start, end time.Time) bool {
+	for _, b := range f.bookings {
+		if b.CopyID == copyID && b.ID != except && f.active(b) && b.StartTime.Before(end) && b.EndTime.After(start) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeBookings) NextFreeSlot(ctx context.Context, bookID int, from time.Time, length time.Duration) (time.Time, bool, error) {
+	var best time.Time
+	found := false
+	for _, copyID := range f.copies[bookID] {
+		booked := []storage.Booking{}
+		for _, b := range f.bookings {
+			if b.CopyID == copyID && f.active(b) && b.EndTime.After(from) {
+				booked = append(booked, b)
+			}
+		}
+		sort.Slice(booked, func(i, j int) bool { return booked[i].StartTime.Before(booked[j].StartTime) })
+		t := from
+		for _, b := range booked {
+			if !b.StartTime.Before(t.Add(length)) {
+				break
+			}
+			if b.EndTime.After(t) {
+				t = b.EndTime
+			}
+		}
+		if !found || t.Before(best) {
+			best, found = t, true
+		}
+	}
+	return best, found, nil
+}
+
+func (f *fakeBookings) Booking(ctx context.Context, id int) (storage.Booking, error) {
+	for _, b := range f.bookings {
+		if b.ID == id {
+			return b, nil
+		}
+	}
+	return storage.Booking{}, storage.ErrNotFound
+}
+
+func (f *fakeBookings) Renew(ctx context.Context, id int, period time.Duration, maxRenewals, performedBy int) error {
+	for i, b := range f.bookings {
+		if b.ID != id {
+			continue
+		}
+		if !f.active(b) || b.EndTime.Before(storage.WallClock()) {
+			return storage.ErrNotRenewable
+		}
+		if b.Renewals >= maxRenewals {
+			return storage.ErrRenewalLimit
+		}
+		if f.overlaps(b.CopyID, b.ID, b.EndTime, b.EndTime.Add(period)) {
+			return storage.ErrConflict
+		}
+		f.bookings[i].EndTime = b.EndTime.Add(period)
+		f.bookings[i].Renewals++
+		return nil
+	}
+	return storage.ErrNotFound
+}
+
+type fakeLedger struct {
+	storage.LedgerStore
+	balances map[int]storage.Money
+}
+
+func (f *fakeLedger) Balance(ctx context.Context, userID int) (storage.Money, error) {
+	return f.balances[userID], nil
+}
+
+type fakePolicies struct {
+	storage.PolicyStore
+	policies []storage.CirculationPolicy
+}
+
+// MatchingPolicies returns the policies for the category and role, most
+// specific first, like the SQL store.
+func (f *fakePolicies) MatchingPolicies(ctx context.Context, categoryID int, role string) ([]storage.CirculationPolicy, error) {
+	matching := []storage.CirculationPolicy{}
+	for _, p := range f.policies {
+		if p.CategoryID != nil && *p.CategoryID == categoryID && p.Role == role {
+			matching = append(matching, p)
+		}
+	}
+	for _, p := range f.policies {
+		if p.CategoryID == nil && p.Role == role {
+			matching = append(matching, p)
+		}
+	}
+	return matching, nil
+}
+
+// fakeOutbox queues mails in memory. ClaimMails hands out each mail once.
+type fakeOutbox struct {
+	storage.OutboxStore
+	mails []storage.OutboxMail
+}
+
+func (f *fakeOutbox) QueueMail(ctx context.Context, to, subject, body string) error {
+	f.mails = append(f.mails, storage.OutboxMail{ID: len(f.mails) + 1, Recipient: to, Subject: subject, Body: body, Status: storage.OutboxPending})
+	return nil
+}
+
+func (f *fakeOutbox) ClaimMails(ctx context.Context, limit int, lease time.Duration) ([]storage.OutboxMail, error) {
+	claimed := []storage.OutboxMail{}
+	for _, mail := range f.mails {
+		if mail.Status == storage.OutboxPending && len(claimed) < limit {
+			claimed = append(claimed, mail)
+		}
+	}
+	return claimed, nil
+}
+
+func (f *fakeOutbox) MarkMailSent(ctx context.Context, id int) error {
+	f.mails[id-1].Status = storage.OutboxSent
+	f.mails[id-1].Body = ""
+	return nil
+}
+
+func (f *fakeOutbox) MarkMailFailed(ctx context.Context, id int, lastError string, retryAt *time.Time) error {
+	f.mails[id-1].Status = storage.OutboxFailed
+	f.mails[id-1].LastError = &lastError
+	return nil
+}
+
+// testLibrary is a small library: Dune has one copy and SPQR two. Ann is a
+// member and Lee a librarian; both use the API with the token named after
+// them.
+type testLibrary struct {
+	books    *fakeBooks
+	users    *fakeUsers
+	bookings *fakeBookings
+	ledger   *fakeLedger
+	policies *fakePolicies
+	outbox   *fakeOutbox
+	cfg      config.Config
+}
+
+const (
+	annID = 1
+	leeID = 2
+
+	duneID = 1
+	spqrID = 2
+
+	scienceFiction = 1
+)
+
+func newTestLibrary() *testLibrary {
+	cfg := config.Default()
+	cfg.SessionKeys = []string{strings.Repeat("k", 32)}
+	return &testLibrary{
+		books: &fakeBooks{books: map[int]storage.Book{
+			duneID: {ID: duneID, Category_id: scienceFiction, Book_name: "Dune", Status: true},
+			spqrID: {ID: spqrID, Category_id: 2, Book_name: "SPQR", Status: true},
+		}},
+		users: &fakeUsers{
+			users: map[int]storage.User{
+				annID: {ID: annID, FirstName: "Ann", Email: "ann@example.com", IsVerified: true, Role: storage.RoleMember},
+				leeID: {ID: leeID, FirstName: "Lee", Email: "lee@example.com", IsVerified: true, Role: storage.RoleLibrarian},
+			},
+			tokens: map[string]storage.APIToken{
+				hashToken("ann"): {ID: 1, UserID: annID},
+				hashToken("lee"): {ID: 2, UserID: leeID},
+			},
+		},
+		bookings: &fakeBookings{copies: map[int][]int{duneID: {1}, spqrID: {2, 3}}},
+		ledger:   &fakeLedger{balances: map[int]storage.Money{}},
+		policies: &fakePolicies{},
+		outbox:   &fakeOutbox{},
+		cfg:      cfg,
+	}
+}
+
+func (l *testLibrary) handler() http.Handler {
+	stores := storage.Stores{
+		Books:    l.books,
+		Bookings: l.bookings,
+		Users:    l.users,
+		Outbox:   l.outbox,
+		Ledger:   l.ledger,
+		Policies: l.policies,
+	}
+	decoder := schema.NewDecoder()
+	decoder.IgnoreUnknownKeys(true)
+	return New(stores, decoder, sessions.NewCookieStore([]byte(l.cfg.SessionKeys[0])), l.cfg)
+}
+
+// apiRequest sends a JSON API request as the owner of token and decodes the
+// response into out when it is not nil.
+func (l *testLibrary) apiRequest(t *testing.T, method, path, token, body string, out interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	l.handler().ServeHTTP(rec, req)
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: decoding %q: %v", method, path, rec.Body.String(), err)
+		}
+	}
+	return rec
+}
+
+// get requests a page without logging in.
+func (l *testLibrary) get(t *testing.T, path string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	l.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec
+}
+
+// formRequest posts a form without logging in.
+func (l *testLibrary) formRequest(t *testing.T, path, form string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	l.handler().ServeHTTP(rec, req)
+	return rec
+}
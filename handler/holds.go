@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"library/storage"
+
+	"github.com/gorilla/mux"
+)
+
+type Hold = storage.Hold
+
+// joinWaitlist queues the user for a title. Joining twice is not an error;
+// the user lands on their bookings page, where the hold is listed.
+func (h *Handler) joinWaitlist(rw http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(rw, "invalid URL", http.StatusInternalServerError)
+		return
+	}
+	if _, err := h.holds.JoinWaitlist(r.Context(), h.access(r).UserID, id); err != nil && err != storage.ErrAlreadyWaiting {
+		if err == storage.ErrInvalidReference {
+			http.Error(rw, "invalid URL", http.StatusNotFound)
+			return
+		}
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(rw, r, "/mybookings", http.StatusSeeOther)
+}
+
+func (h *Handler) cancelHold(rw http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(rw, "invalid URL", http.StatusInternalServerError)
+		return
+	}
+	if err := h.holds.CancelHold(r.Context(), id, h.access(r).UserID); err != nil {
+		if err == storage.ErrNotFound {
+			http.Error(rw, "invalid URL", http.StatusNotFound)
+			return
+		}
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	redirectBack(rw, r, "/mybookings")
+}
@@ -1,22 +1,19 @@
 package handler
 
 import (
-	"log"
 	"net/http"
 )
 
 type Auth struct {
 	Auth	interface{}
+	Access	Access
 }
 
 func (h *Handler) home(rw http.ResponseWriter, r *http.Request) {
-	session, err := h.sess.Get(r, sessionName)
-	if err != nil {
-		log.Fatal(err)
-	}
-	auth := session.Values["authUserID"]
-	list := Auth{
-		Auth: auth,
+	list := Auth{}
+	if access, ok := h.sessionAccess(r); ok {
+		list.Auth = access.UserID
+		list.Access = access
 	}
 	if err:= h.templates.ExecuteTemplate(rw, "home.html", list); err != nil {
 	http.Error(rw, err.Error(), http.StatusInternalServerError)
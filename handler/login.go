@@ -1,9 +1,10 @@
 package handler
 
 import (
-	"log"
 	"net/http"
 
+	"library/storage"
+
 	validation "github.com/go-ozzo/ozzo-validation"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -12,6 +13,8 @@ type LoginForm struct {
 	Email	string
 	Password	string
 	Errors	map[string]string
+	Message	string
+	Unverified	bool
 }
 
 func (l *LoginForm) Validate() error {
@@ -25,6 +28,9 @@ func (l *LoginForm) Validate() error {
 
 func (h *Handler) login(rw http.ResponseWriter, r *http.Request) {
 	form := LoginForm{}
+	if r.URL.Query().Get("registered") != "" {
+		form.Message = "Your account has been created. Please check your email for the verification link."
+	}
 	if err:= h.templates.ExecuteTemplate(rw, "login.html", form); err != nil {
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
@@ -59,10 +65,12 @@ func (h *Handler) loginCheck(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userQuery := `SELECT * FROM users WHERE email = $1`
-	var user SignUp
-	h.db.Get(&user, userQuery, login.Email)
-	if user.Email == "" {
+	user, err := h.users.UserByEmail(r.Context(), login.Email)
+	if err != nil && err != storage.ErrNotFound {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err == storage.ErrNotFound {
 		login.Errors = map[string]string{"Email" : "Invalid email given."}
 		h.loadLoginForm(rw, login)
 		return
@@ -74,19 +82,24 @@ func (h *Handler) loginCheck(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	session, err := h.sess.Get(r, sessionName)
-	if err != nil {
-		log.Fatal(err)
+	if !user.IsVerified {
+		login.Errors = map[string]string{"Email" : "Please verify your email address before logging in."}
+		login.Unverified = true
+		h.loadLoginForm(rw, login)
+		return
 	}
 
+	// a cookie signed with a retired key fails to decode; Get still
+	// returns a new session, which the login then fills in
+	session, _ := h.sess.Get(r, sessionName)
+
 	session.Options.HttpOnly = true
 
 	session.Values["authUserID"] = user.ID
+	session.Values["sessionVersion"] = user.SessionVersion
 	if err := session.Save(r, rw); err != nil {
-		log.Fatal(err)
-	}
-	if err := session.Save(r, rw); err != nil {
-		log.Fatal(err)
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	http.Redirect(rw, r, "/book/list", http.StatusTemporaryRedirect)
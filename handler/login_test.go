@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// retiredCookie returns a session cookie signed with a key the site no
+// longer accepts, like one kept by a browser across a key rotation.
+func retiredCookie(t *testing.T) *http.Cookie {
+	t.Helper()
+	old := sessions.NewCookieStore([]byte(strings.Repeat("o", 32)))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	session, _ := old.Get(req, sessionName)
+	session.Values["authUserID"] = annID
+	if err := session.Save(req, rec); err != nil {
+		t.Fatal(err)
+	}
+	return rec.Result().Cookies()[0]
+}
+
+func TestLoginWithRetiredSessionCookie(t *testing.T) {
+	lib := newTestLibrary()
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret1"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ann := lib.users.users[annID]
+	ann.Password = string(hash)
+	lib.users.users[annID] = ann
+
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader("Email=ann@example.com&Password=secret1"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(retiredCookie(t))
+	rec := httptest.NewRecorder()
+	lib.handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusTemporaryRedirect || rec.Header().Get("Location") != "/book/list" {
+		t.Fatalf("login: got %d to %q, want a redirect to the books", rec.Code, rec.Header().Get("Location"))
+	}
+	if len(rec.Result().Cookies()) != 1 {
+		t.Errorf("login: got cookies %v, want a new session cookie", rec.Result().Cookies())
+	}
+}
+
+func TestLogoutWithRetiredSessionCookie(t *testing.T) {
+	lib := newTestLibrary()
+	req := httptest.NewRequest(http.MethodGet, "/logout", nil)
+	req.AddCookie(retiredCookie(t))
+	rec := httptest.NewRecorder()
+	lib.handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusTemporaryRedirect || rec.Header().Get("Location") != "/login" {
+		t.Errorf("logout: got %d to %q, want a redirect to the login page", rec.Code, rec.Header().Get("Location"))
+	}
+}
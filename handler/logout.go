@@ -3,18 +3,17 @@
 package handler
 
 import (
-	"log"
 	"net/http"
 )
 
 func (h *Handler) logout(rw http.ResponseWriter, r *http.Request) {
-	session, err := h.sess.Get(r, sessionName)
-	if err != nil {
-		log.Fatal(err)
-	}
+	// a cookie that no longer decodes gives a new, empty session, and
+	// saving it replaces the stale cookie
+	session, _ := h.sess.Get(r, sessionName)
 	session.Values["authUserID"] = nil
 	if err := session.Save(r, rw); err != nil {
-		log.Fatal(err)
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	http.Redirect(rw, r, "/login", http.StatusTemporaryRedirect)
@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"library/mailer"
+	"library/storage"
+)
+
+// MailData fills templates/mail-template.html.
+type MailData = mailer.TemplateData
+
+// queueMail renders the mail template and stores the mail in the outbox.
+// The scheduler's mail job delivers it, so requests never wait on SMTP.
+func (h *Handler) queueMail(ctx context.Context, to string, subject string, data MailData) error {
+	body, err := mailer.Render(data)
+	if err != nil {
+		return err
+	}
+	return h.outbox.QueueMail(ctx, to, subject, body)
+}
+
+// bookingMail is the subject and message of a booking notification. The
+// message gets the book name and the start and end times.
+type bookingMail struct{ subject, message string }
+
+// bookingMails holds the notification sent when a booking enters a status.
+// Statuses without an entry do not send mail.
+var bookingMails = map[string]bookingMail{
+	storage.BookingReserved:   {"Booking Confirmed", "Your booking of %s from %s to %s is confirmed."},
+	storage.BookingCheckedOut: {"Book Checked Out", "You have checked out %s. Please return it by %[3]s."},
+	storage.BookingReturned:   {"Book Returned", "Thanks for returning %[1]s."},
+	storage.BookingCancelled:  {"Booking Cancelled", "Your booking of %s from %s to %s has been cancelled."},
+}
+
+// renewalMail is sent when a booking is renewed.
+var renewalMail = bookingMail{"Booking Renewed", "Your booking of %s has been renewed. It now ends %[3]s."}
+
+// notifyBooking mails the booking's owner about its current status. Mail
+// failures are logged and never undo the booking change.
+func (h *Handler) notifyBooking(ctx context.Context, bookingID int) {
+	booking, err := h.bookings.Booking(ctx, bookingID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	mail, ok := bookingMails[booking.Status]
+	if !ok {
+		return
+	}
+	h.mailBooking(ctx, booking, mail)
+}
+
+// notifyRenewal mails the booking's owner its new end time.
+func (h *Handler) notifyRenewal(ctx context.Context, bookingID int) {
+	booking, err := h.bookings.Booking(ctx, bookingID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	h.mailBooking(ctx, booking, renewalMail)
+}
+
+func (h *Handler) mailBooking(ctx context.Context, booking Bookings, mail bookingMail) {
+	bookings := []Bookings{booking}
+	fillBookingDetails(bookings)
+	booking = bookings[0]
+
+	user, err := h.users.User(ctx, booking.UserID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	data := MailData{
+		Name:       user.FirstName,
+		Title:      mail.subject,
+		Message:    fmt.Sprintf(mail.message, booking.BookName, booking.Start_time, booking.End_time),
+		Link:       h.cfg.BaseURL + "/mybookings",
+		ButtonText: "My Bookings",
+	}
+	if err := h.queueMail(ctx, user.Email, mail.subject, data); err != nil {
+		log.Println(err)
+	}
+}
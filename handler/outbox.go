@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"library/storage"
+
+	"github.com/gorilla/mux"
+)
+
+var outboxStatuses = []string{storage.OutboxPending, storage.OutboxFailed, storage.OutboxSent}
+
+type OutboxMail = storage.OutboxMail
+
+type ListOutbox struct {
+	Mails    []OutboxMail
+	Statuses []string
+	Status   string
+	Access   Access
+}
+
+func (h *Handler) listOutbox(rw http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	mails, err := h.outbox.OutboxMails(r.Context(), status, 100)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	list := ListOutbox{
+		Mails:    mails,
+		Statuses: outboxStatuses,
+		Status:   status,
+		Access:   h.access(r),
+	}
+	if err := h.templates.ExecuteTemplate(rw, "list-outbox.html", list); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// retryOutbox puts a failed or pending mail back at the front of the queue
+// with a fresh set of attempts.
+func (h *Handler) retryOutbox(rw http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(rw, "invalid URL", http.StatusInternalServerError)
+		return
+	}
+	if err := h.outbox.RetryMail(r.Context(), id); err != nil {
+		if err == storage.ErrNotFound {
+			http.Error(rw, "invalid URL", http.StatusNotFound)
+			return
+		}
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	redirectBack(rw, r, "/outbox")
+}
@@ -0,0 +1,285 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"library/storage"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/gorilla/mux"
+)
+
+type CirculationPolicy = storage.CirculationPolicy
+
+// PolicyForm is a circulation policy as typed into the form. A limit left
+// empty is inherited from the less specific policies and the defaults.
+type PolicyForm struct {
+	ID	int
+	CategoryID	int
+	Role	string
+	MaxLoanDays	string
+	MaxItemsOut	string
+	MaxRenewals	string
+	FinePerDay	string
+}
+
+type ListPolicies struct {
+	Policies	[]CirculationPolicy
+	Defaults	storage.Limits
+	Access	Access
+}
+
+type FormPolicy struct {
+	Policy	PolicyForm
+	Categories	[]Category
+	Roles	[]string
+	Errors	map[string]string
+}
+
+func validatePolicy(p *PolicyForm) error {
+	roleNames := make([]interface{}, len(roles))
+	for i, role := range roles {
+		roleNames[i] = role
+	}
+	return validation.ValidateStruct(p,
+		validation.Field(&p.Role,
+			validation.In(roleNames...).Error("Please choose a role"),
+		),
+		validation.Field(&p.MaxLoanDays, validation.By(isLimit)),
+		validation.Field(&p.MaxItemsOut, validation.By(isLimit)),
+		validation.Field(&p.MaxRenewals, validation.By(isLimit)),
+		validation.Field(&p.FinePerDay, validation.By(isFine)),
+	)
+}
+
+func isLimit(value interface{}) error {
+	s := strings.TrimSpace(value.(string))
+	if s == "" {
+		return nil
+	}
+	if n, err := strconv.Atoi(s); err != nil || n < 0 {
+		return errors.New("Enter a whole number of 0 or more, or leave it empty to inherit")
+	}
+	return nil
+}
+
+func isFine(value interface{}) error {
+	s := strings.TrimSpace(value.(string))
+	if s == "" {
+		return nil
+	}
+	var fine storage.Money
+	if err := fine.UnmarshalText([]byte(s)); err != nil || fine < 0 {
+		return errors.New("Enter an amount of 0 or more, or leave it empty to inherit")
+	}
+	return nil
+}
+
+// policy returns the policy the form describes. It must only be called once
+// validatePolicy has succeeded.
+func (p PolicyForm) policy() CirculationPolicy {
+	policy := CirculationPolicy{
+		ID: p.ID,
+		Role: p.Role,
+		MaxLoanDays: limitValue(p.MaxLoanDays),
+		MaxItemsOut: limitValue(p.MaxItemsOut),
+		MaxRenewals: limitValue(p.MaxRenewals),
+	}
+	if p.CategoryID > 0 {
+		categoryID := p.CategoryID
+		policy.CategoryID = &categoryID
+	}
+	if s := strings.TrimSpace(p.FinePerDay); s != "" {
+		var fine storage.Money
+		fine.UnmarshalText([]byte(s))
+		policy.FinePerDay = &fine
+	}
+	return policy
+}
+
+func limitValue(s string) *int {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil
+	}
+	n, _ := strconv.Atoi(s)
+	return &n
+}
+
+func policyForm(p CirculationPolicy) PolicyForm {
+	form := PolicyForm{ID: p.ID, Role: p.Role}
+	if p.CategoryID != nil {
+		form.CategoryID = *p.CategoryID
+	}
+	if p.MaxLoanDays != nil {
+		form.MaxLoanDays = strconv.Itoa(*p.MaxLoanDays)
+	}
+	if p.MaxItemsOut != nil {
+		form.MaxItemsOut = strconv.Itoa(*p.MaxItemsOut)
+	}
+	if p.MaxRenewals != nil {
+		form.MaxRenewals = strconv.Itoa(*p.MaxRenewals)
+	}
+	if p.FinePerDay != nil {
+		form.FinePerDay = p.FinePerDay.String()
+	}
+	return form
+}
+
+func (h *Handler) listPolicies(rw http.ResponseWriter, r *http.Request) {
+	policies, err := h.policies.Policies(r.Context())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	list := ListPolicies{
+		Policies: policies,
+		Defaults: storage.Limits{
+			MaxLoanDays: h.cfg.Loans.MaxLoanDays,
+			MaxItemsOut: h.cfg.Loans.MaxItemsOut,
+			MaxRenewals: h.cfg.Loans.MaxRenewals,
+			FinePerDay: storage.Money(h.cfg.Loans.FinePerDay),
+		},
+		Access: h.access(r),
+	}
+	if err := h.templates.ExecuteTemplate(rw, "list-policies.html", list); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *Handler) createPolicy(rw http.ResponseWriter, r *http.Request) {
+	h.loadPolicyForm(rw, r, PolicyForm{}, map[string]string{})
+}
+
+func (h *Handler) storePolicy(rw http.ResponseWriter, r *http.Request) {
+	form, ok := h.decodePolicyForm(rw, r)
+	if !ok {
+		return
+	}
+	policy := form.policy()
+	if err := h.policies.CreatePolicy(r.Context(), &policy); err != nil {
+		h.policyStoreError(rw, r, form, err)
+		return
+	}
+	http.Redirect(rw, r, "/policies", http.StatusSeeOther)
+}
+
+func (h *Handler) editPolicy(rw http.ResponseWriter, r *http.Request) {
+	policy, ok := h.getPolicyFromURL(rw, r)
+	if !ok {
+		return
+	}
+	h.loadPolicyForm(rw, r, policyForm(policy), map[string]string{})
+}
+
+func (h *Handler) updatePolicy(rw http.ResponseWriter, r *http.Request) {
+	if _, ok := h.getPolicyFromURL(rw, r); !ok {
+		return
+	}
+	form, ok := h.decodePolicyForm(rw, r)
+	if !ok {
+		return
+	}
+	if err := h.policies.UpdatePolicy(r.Context(), form.policy()); err != nil {
+		h.policyStoreError(rw, r, form, err)
+		return
+	}
+	http.Redirect(rw, r, "/policies", http.StatusSeeOther)
+}
+
+func (h *Handler) deletePolicy(rw http.ResponseWriter, r *http.Request) {
+	policy, ok := h.getPolicyFromURL(rw, r)
+	if !ok {
+		return
+	}
+	if err := h.policies.DeletePolicy(r.Context(), policy.ID); err != nil && err != storage.ErrNotFound {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(rw, r, "/policies", http.StatusSeeOther)
+}
+
+// decodePolicyForm reads and validates the submitted form. When it reports
+// false the response has been written, either the form again with its
+// errors or an error page.
+func (h *Handler) decodePolicyForm(rw http.ResponseWriter, r *http.Request) (PolicyForm, bool) {
+	var form PolicyForm
+	if err := r.ParseForm(); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return form, false
+	}
+	if err := h.decoder.Decode(&form, r.PostForm); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return form, false
+	}
+	if id, err := strconv.Atoi(mux.Vars(r)["id"]); err == nil {
+		form.ID = id
+	}
+	if err := validatePolicy(&form); err != nil {
+		vErrors, ok := err.(validation.Errors)
+		if ok {
+			vErrs := make(map[string]string)
+			for key, value := range vErrors {
+				vErrs[key] = value.Error()
+			}
+			h.loadPolicyForm(rw, r, form, vErrs)
+			return form, false
+		}
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return form, false
+	}
+	return form, true
+}
+
+func (h *Handler) policyStoreError(rw http.ResponseWriter, r *http.Request, form PolicyForm, err error) {
+	switch err {
+	case storage.ErrPolicyExists:
+		h.loadPolicyForm(rw, r, form, map[string]string{"Role": err.Error()})
+	case storage.ErrInvalidReference:
+		h.loadPolicyForm(rw, r, form, map[string]string{"CategoryID": "Please choose a category"})
+	case storage.ErrNotFound:
+		http.Error(rw, "invalid URL", http.StatusNotFound)
+	default:
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (h *Handler) loadPolicyForm(rw http.ResponseWriter, r *http.Request, policy PolicyForm, errs map[string]string) {
+	categories, err := h.categories.Categories(r.Context())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	form := FormPolicy{
+		Policy: policy,
+		Categories: categories,
+		Roles: roles,
+		Errors: errs,
+	}
+	if err := h.templates.ExecuteTemplate(rw, "policy-form.html", form); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *Handler) getPolicyFromURL(rw http.ResponseWriter, r *http.Request) (CirculationPolicy, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(rw, "invalid URL", http.StatusInternalServerError)
+		return CirculationPolicy{}, false
+	}
+	policy, err := h.policies.Policy(r.Context(), id)
+	if err == storage.ErrNotFound {
+		http.Error(rw, "invalid URL", http.StatusNotFound)
+		return policy, false
+	}
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return policy, false
+	}
+	return policy, true
+}
@@ -1,16 +1,189 @@
 package handler
 
-import "net/http"
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"library/storage"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// resetTokenTTL is how long a password reset link stays valid.
+const resetTokenTTL = time.Hour
 
 type EmailForm struct {
 	Email	string
 	Errors	map[string]string
+	Sent	bool
+}
+
+type NewPasswordForm struct {
+	Token	string
+	Password	string
+	ConfirmPassword	string
+	Errors	map[string]string
+}
+
+func (e *EmailForm) Validate() error {
+	return validation.ValidateStruct(e,
+	validation.Field(&e.Email,
+		validation.Required.Error("The email field is must required")))
+}
+
+func (n *NewPasswordForm) Validate() error {
+	return validation.ValidateStruct(n,
+	validation.Field(&n.Password,
+		validation.Required.Error("The password field is must required"),
+		validation.Length(6, 32).Error("The password must be between 6 to 32 characters.")),
+	validation.Field(&n.ConfirmPassword,
+		validation.Required.Error("The confirm password field is must required"),
+		validation.In(n.Password).Error("The password does not match with the confirm password")))
 }
 
 func (h *Handler) forgotPassword(rw http.ResponseWriter, r *http.Request) {
 	form := EmailForm{}
+	h.loadEmailForm(rw, form)
+}
+
+// sendResetLink always answers the same way whether or not the email is
+// registered, so the form cannot be used to discover accounts.
+func (h *Handler) sendResetLink(rw http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var form EmailForm
+	if err := h.decoder.Decode(&form, r.PostForm); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := form.Validate(); err != nil {
+		vErrors, ok := err.(validation.Errors)
+		if ok {
+			vErrs := make(map[string]string)
+			for key, value := range vErrors {
+				vErrs[key] = value.Error()
+			}
+			form.Errors = vErrs
+			h.loadEmailForm(rw, form)
+			return
+		}
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	user, err := h.users.UserByEmail(r.Context(), form.Email)
+	if err != nil && err != storage.ErrNotFound {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err == nil {
+		token, err := newToken()
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		reset := storage.PasswordReset{UserID: user.ID, TokenHash: hashToken(token), ExpiresAt: time.Now().Add(resetTokenTTL)}
+		if err := h.users.CreatePasswordReset(r.Context(), &reset); err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		mail := MailData{
+			Name: user.FirstName,
+			Title: "Reset Your Password",
+			Message: fmt.Sprintf("We received a request to reset your password. The link below is valid for %d minutes. If you did not ask for this you can ignore this mail.", int(resetTokenTTL.Minutes())),
+			Link: h.cfg.BaseURL + "/resetpassword/" + token,
+			ButtonText: "Reset Password",
+		}
+		if err := h.queueMail(r.Context(), user.Email, "Password Reset", mail); err != nil {
+			log.Println(err)
+		}
+	}
+
+	h.loadEmailForm(rw, EmailForm{Sent: true})
+}
+
+func (h *Handler) newPassword(rw http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+	if _, ok := h.findReset(r, token); !ok {
+		h.loadEmailForm(rw, EmailForm{Errors: map[string]string{"Email": "This reset link is invalid or has expired. Please request a new one."}})
+		return
+	}
+	h.loadNewPasswordForm(rw, NewPasswordForm{Token: token})
+}
+
+func (h *Handler) storeNewPassword(rw http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+	reset, ok := h.findReset(r, token)
+	if !ok {
+		h.loadEmailForm(rw, EmailForm{Errors: map[string]string{"Email": "This reset link is invalid or has expired. Please request a new one."}})
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var form NewPasswordForm
+	if err := h.decoder.Decode(&form, r.PostForm); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	form.Token = token
+	if err := form.Validate(); err != nil {
+		vErrors, ok := err.(validation.Errors)
+		if ok {
+			vErrs := make(map[string]string)
+			for key, value := range vErrors {
+				vErrs[key] = value.Error()
+			}
+			form.Errors = vErrs
+			h.loadNewPasswordForm(rw, form)
+			return
+		}
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	pass, err := bcrypt.GenerateFromPassword([]byte(form.Password), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.users.ResetPassword(r.Context(), reset.ID, string(pass)); err != nil {
+		if err == storage.ErrNotFound {
+			h.loadEmailForm(rw, EmailForm{Errors: map[string]string{"Email": "This reset link has already been used."}})
+			return
+		}
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.loadLoginForm(rw, LoginForm{Message: "Your password has been reset. Please log in with the new password."})
+}
+
+// findReset returns the unused, unexpired reset matching a plain token.
+func (h *Handler) findReset(r *http.Request, token string) (storage.PasswordReset, bool) {
+	reset, err := h.users.PasswordReset(r.Context(), hashToken(token))
+	return reset, err == nil
+}
+
+func (h *Handler) loadEmailForm(rw http.ResponseWriter, form EmailForm) {
 	if err:= h.templates.ExecuteTemplate(rw, "reset-password.html", form); err != nil {
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
-}
\ No newline at end of file
+}
+
+func (h *Handler) loadNewPasswordForm(rw http.ResponseWriter, form NewPasswordForm) {
+	if err:= h.templates.ExecuteTemplate(rw, "new-password.html", form); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"library/mailer"
+	"library/scheduler"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var resetLink = regexp.MustCompile(`href="[^"]*(/resetpassword/[^"]+)"`)
+
+// deliver sends the queued mail through the mail job into a Memory mailer.
+func (l *testLibrary) deliver(t *testing.T) []mailer.Message {
+	t.Helper()
+	memory := &mailer.Memory{}
+	if err := scheduler.SendMail(l.outbox, memory)(context.Background()); err != nil {
+		t.Fatalf("sending mail: %v", err)
+	}
+	return memory.Messages()
+}
+
+func TestPasswordReset(t *testing.T) {
+	lib := newTestLibrary()
+
+	if rec := lib.formRequest(t, "/resetpassword", "Email=ann@example.com"); rec.Code != http.StatusOK {
+		t.Fatalf("requesting a reset: got %d %s, want 200", rec.Code, rec.Body)
+	}
+	messages := lib.deliver(t)
+	if len(messages) != 1 || len(messages[0].To) != 1 || messages[0].To[0] != "ann@example.com" {
+		t.Fatalf("got messages %+v, want one to ann@example.com", messages)
+	}
+	match := resetLink.FindStringSubmatch(messages[0].HTML)
+	if match == nil {
+		t.Fatalf("no reset link in %q", messages[0].HTML)
+	}
+	link := match[1]
+
+	rec := lib.get(t, link)
+	if !strings.Contains(rec.Body.String(), `name="Password"`) {
+		t.Fatalf("opening the link: got %d %s, want the new password form", rec.Code, rec.Body)
+	}
+
+	rec = lib.formRequest(t, link, url.Values{"Password": {"secret1"}, "ConfirmPassword": {"other1"}}.Encode())
+	if !strings.Contains(rec.Body.String(), "does not match") {
+		t.Errorf("mismatched passwords: got %s, want the form with an error", rec.Body)
+	}
+
+	rec = lib.formRequest(t, link, url.Values{"Password": {"secret1"}, "ConfirmPassword": {"secret1"}}.Encode())
+	if !strings.Contains(rec.Body.String(), "Your password has been reset") {
+		t.Fatalf("storing the password: got %d %s, want the login form", rec.Code, rec.Body)
+	}
+	ann := lib.users.users[annID]
+	if err := bcrypt.CompareHashAndPassword([]byte(ann.Password), []byte("secret1")); err != nil {
+		t.Errorf("stored password does not match: %v", err)
+	}
+	if ann.SessionVersion != 1 {
+		t.Errorf("got session version %d, want the sessions logged out", ann.SessionVersion)
+	}
+	if rec := lib.apiRequest(t, "GET", "/api/v1/users/me", "ann", "", nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("API token after the reset: got %d, want 401", rec.Code)
+	}
+
+	rec = lib.formRequest(t, link, url.Values{"Password": {"secret2"}, "ConfirmPassword": {"secret2"}}.Encode())
+	if !strings.Contains(rec.Body.String(), "invalid or has expired") {
+		t.Errorf("reusing the link: got %s, want it refused", rec.Body)
+	}
+}
+
+func TestPasswordResetUnknownEmail(t *testing.T) {
+	lib := newTestLibrary()
+
+	known := lib.formRequest(t, "/resetpassword", "Email=ann@example.com")
+	lib.deliver(t)
+	unknown := lib.formRequest(t, "/resetpassword", "Email=nobody@example.com")
+	if messages := lib.deliver(t); len(messages) != 0 {
+		t.Errorf("got %d messages for an unknown email, want none", len(messages))
+	}
+	if known.Code != unknown.Code || known.Body.String() != unknown.Body.String() {
+		t.Errorf("the answer differs for unknown emails, which gives accounts away")
+	}
+}
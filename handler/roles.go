@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"library/storage"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	permManageCatalog  = "catalog:manage"
+	permManageBookings = "bookings:manage"
+	permManageUsers    = "users:manage"
+	permManagePolicies = "policies:manage"
+)
+
+var roles = []string{storage.RoleAdmin, storage.RoleLibrarian, storage.RoleMember}
+
+var rolePermissions = map[string][]string{
+	storage.RoleAdmin:     {permManageCatalog, permManageBookings, permManageUsers, permManagePolicies},
+	storage.RoleLibrarian: {permManageCatalog, permManageBookings},
+	storage.RoleMember:    {},
+}
+
+// Access describes what the logged in user may do. It is passed to templates
+// so they can hide actions the user is not allowed to take.
+type Access struct {
+	UserID int
+	Role   string
+}
+
+func (a Access) Can(perm string) bool {
+	for _, p := range rolePermissions[a.Role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+type accessKey struct{}
+
+// access returns the Access stored by authMiddleware for this request.
+func (h *Handler) access(r *http.Request) Access {
+	if a, ok := r.Context().Value(accessKey{}).(Access); ok {
+		return a
+	}
+	a, _ := h.sessionAccess(r)
+	return a
+}
+
+func withAccess(r *http.Request, a Access) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), accessKey{}, a))
+}
+
+// permissionMiddleware rejects requests from users whose role lacks perm.
+// It must run after authMiddleware.
+func (h *Handler) permissionMiddleware(perm string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			if !h.access(r).Can(perm) {
+				http.Error(rw, "You do not have permission to access this page", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+type ListUsers struct {
+	Users  []SignUp
+	Roles  []string
+	Access Access
+}
+
+func (h *Handler) listUsers(rw http.ResponseWriter, r *http.Request) {
+	users, err := h.users.Users(r.Context())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	list := ListUsers{
+		Users:  users,
+		Roles:  roles,
+		Access: h.access(r),
+	}
+	if err := h.templates.ExecuteTemplate(rw, "list-users.html", list); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *Handler) updateUserRole(rw http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(rw, "invalid URL", http.StatusInternalServerError)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	role := r.FormValue("Role")
+	if _, ok := rolePermissions[role]; !ok {
+		http.Error(rw, fmt.Sprintf("unknown role %q", role), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.users.SetRole(r.Context(), id, role); err != nil {
+		switch err {
+		case storage.ErrNotFound:
+			http.Error(rw, "invalid URL", http.StatusInternalServerError)
+		case storage.ErrLastAdmin:
+			http.Error(rw, "The last admin cannot be demoted", http.StatusConflict)
+		default:
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	http.Redirect(rw, r, "/users", http.StatusSeeOther)
+}
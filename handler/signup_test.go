@@ -0,0 +1,25 @@
+package handler
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestSignUpEmailTaken(t *testing.T) {
+	lib := newTestLibrary()
+	form := url.Values{
+		"FirstName":       {"Annie"},
+		"LastName":        {"Smith"},
+		"Email":           {"ANN@example.com"},
+		"Password":        {"secret1"},
+		"ConfirmPassword": {"secret1"},
+	}
+	rec := lib.formRequest(t, "/registration", form.Encode())
+	if !strings.Contains(rec.Body.String(), "An account with this email already exists") {
+		t.Errorf("signing up with a taken email: got %d %s, want the form with an error", rec.Code, rec.Body)
+	}
+	if len(lib.users.users) != 2 || len(lib.outbox.mails) != 0 {
+		t.Errorf("got %d users and %d mails, want nothing created", len(lib.users.users), len(lib.outbox.mails))
+	}
+}
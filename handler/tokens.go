@@ -0,0 +1,167 @@
+package handler
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"library/storage"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/gorilla/mux"
+)
+
+// tokenPrefix marks personal access tokens so they are easy to spot in
+// logs and secret scanners.
+const tokenPrefix = "lib_"
+
+type APIToken = storage.APIToken
+
+type Profile struct {
+	User     SignUp
+	Tokens   []APIToken
+	NewToken string
+	Errors   map[string]string
+	Access   Access
+}
+
+func validateToken(t *APIToken) error {
+	return validation.ValidateStruct(t,
+		validation.Field(&t.Name,
+			validation.Required.Error("The Name Field is Required"),
+			validation.Length(0, 64).Error("The Name must be at most 64 characters"),
+		),
+	)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return tokenPrefix + hex.EncodeToString(b), nil
+}
+
+// tokenMiddleware authenticates requests that carry an
+// "Authorization: Bearer" header. A valid token stores the same Access that
+// authMiddleware would, so the rest of the stack does not care how the user
+// logged in. Requests without the header fall through to the session.
+func (h *Handler) tokenMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if !strings.HasPrefix(header, "Bearer ") {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		token, err := h.users.APITokenByHash(r.Context(), hashToken(strings.TrimPrefix(header, "Bearer ")))
+		if err == storage.ErrNotFound {
+			writeError(rw, http.StatusUnauthorized, "invalid or revoked token")
+			return
+		}
+		if err != nil {
+			writeError(rw, http.StatusInternalServerError, err.Error())
+			return
+		}
+		user, err := h.users.User(r.Context(), token.UserID)
+		if err != nil {
+			writeLookupError(rw, err, "user not found")
+			return
+		}
+		next.ServeHTTP(rw, withAccess(r, Access{UserID: user.ID, Role: user.Role}))
+	})
+}
+
+func (h *Handler) profile(rw http.ResponseWriter, r *http.Request) {
+	h.loadProfile(rw, r, "", map[string]string{})
+}
+
+func (h *Handler) storeToken(rw http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var token APIToken
+	if err := h.decoder.Decode(&token, r.PostForm); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := validateToken(&token); err != nil {
+		vErrors, ok := err.(validation.Errors)
+		if ok {
+			vErrs := make(map[string]string)
+			for key, value := range vErrors {
+				vErrs[key] = value.Error()
+			}
+			h.loadProfile(rw, r, "", vErrs)
+			return
+		}
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	plain, err := newToken()
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	token.UserID = h.access(r).UserID
+	token.TokenHash = hashToken(plain)
+	token.Hint = plain[len(plain)-4:]
+	if err := h.users.CreateAPIToken(r.Context(), &token); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// the plain token is only ever shown on this response
+	h.loadProfile(rw, r, plain, map[string]string{})
+}
+
+func (h *Handler) revokeToken(rw http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(rw, "invalid URL", http.StatusInternalServerError)
+		return
+	}
+	if err := h.users.RevokeAPIToken(r.Context(), id, h.access(r).UserID); err != nil {
+		if err == storage.ErrNotFound {
+			http.Error(rw, "invalid URL", http.StatusNotFound)
+			return
+		}
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(rw, r, "/profile", http.StatusSeeOther)
+}
+
+func (h *Handler) loadProfile(rw http.ResponseWriter, r *http.Request, newToken string, errs map[string]string) {
+	access := h.access(r)
+	user, err := h.users.User(r.Context(), access.UserID)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tokens, err := h.users.APITokens(r.Context(), access.UserID)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data := Profile{
+		User:     user,
+		Tokens:   tokens,
+		NewToken: newToken,
+		Errors:   errs,
+		Access:   access,
+	}
+	if err := h.templates.ExecuteTemplate(rw, "profile.html", data); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"library/storage"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/gorilla/securecookie"
+)
+
+// verifyTokenName namespaces verification tokens so they can never be
+// confused with the session cookie, which is signed with the same keys.
+const verifyTokenName = "verify-email"
+
+// verifyTokenTTL is how long a verification link stays valid.
+const verifyTokenTTL = 48 * time.Hour
+
+// verifyClaims is the signed payload of a verification link. The email is
+// included so a link stops working if the address is changed.
+type verifyClaims struct {
+	UserID   int
+	Email    string
+	IssuedAt int64
+}
+
+func (h *Handler) verifyToken(user SignUp) (string, error) {
+	claims := verifyClaims{UserID: user.ID, Email: user.Email, IssuedAt: time.Now().Unix()}
+	return securecookie.EncodeMulti(verifyTokenName, claims, h.sess.Codecs...)
+}
+
+// sendVerification mails a fresh verification link to the user.
+func (h *Handler) sendVerification(ctx context.Context, user SignUp) error {
+	token, err := h.verifyToken(user)
+	if err != nil {
+		return err
+	}
+	mail := MailData{
+		Name: user.FirstName,
+		Title: "Verify Your Email Account",
+		Message: "Thanks for signing up. Please click the button below to verify your email address. The link is valid for 48 hours.",
+		Link: h.cfg.BaseURL + "/verify?token=" + url.QueryEscape(token),
+		ButtonText: "Verify Email",
+	}
+	return h.queueMail(ctx, user.Email, "Verification Mail", mail)
+}
+
+func (h *Handler) verifyEmail(rw http.ResponseWriter, r *http.Request) {
+	var claims verifyClaims
+	err := securecookie.DecodeMulti(verifyTokenName, r.URL.Query().Get("token"), &claims, h.sess.Codecs...)
+	if err != nil || time.Since(time.Unix(claims.IssuedAt, 0)) > verifyTokenTTL {
+		h.loadResendForm(rw, EmailForm{Errors: map[string]string{"Email": "This verification link is invalid or has expired. Please request a new one."}})
+		return
+	}
+
+	user, err := h.users.User(r.Context(), claims.UserID)
+	if err != nil && err != storage.ErrNotFound {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err == storage.ErrNotFound || user.Email != claims.Email {
+		h.loadResendForm(rw, EmailForm{Errors: map[string]string{"Email": "This verification link is invalid or has expired. Please request a new one."}})
+		return
+	}
+	if !user.IsVerified {
+		if err := h.users.MarkVerified(r.Context(), user.ID); err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	h.loadLoginForm(rw, LoginForm{Email: user.Email, Message: "Your email has been verified. You can log in now."})
+}
+
+func (h *Handler) resendVerification(rw http.ResponseWriter, r *http.Request) {
+	h.loadResendForm(rw, EmailForm{})
+}
+
+// storeResendVerification answers the same way for unknown, unverified and
+// already verified addresses so it cannot be used to discover accounts.
+func (h *Handler) storeResendVerification(rw http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var form EmailForm
+	if err := h.decoder.Decode(&form, r.PostForm); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := form.Validate(); err != nil {
+		vErrors, ok := err.(validation.Errors)
+		if ok {
+			vErrs := make(map[string]string)
+			for key, value := range vErrors {
+				vErrs[key] = value.Error()
+			}
+			form.Errors = vErrs
+			h.loadResendForm(rw, form)
+			return
+		}
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	user, err := h.users.UserByEmail(r.Context(), form.Email)
+	if err != nil && err != storage.ErrNotFound {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err == nil && !user.IsVerified {
+		if err := h.sendVerification(r.Context(), user); err != nil {
+			log.Println(err)
+		}
+	}
+	h.loadResendForm(rw, EmailForm{Sent: true})
+}
+
+func (h *Handler) loadResendForm(rw http.ResponseWriter, form EmailForm) {
+	if err:= h.templates.ExecuteTemplate(rw, "resend-verification.html", form); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
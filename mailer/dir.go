@@ -0,0 +1,30 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Dir writes every message as an .eml file into a directory so mail can be
+// inspected during local development without an SMTP server.
+type Dir struct {
+	Path string
+	From string
+}
+
+func (d *Dir) Send(ctx context.Context, msg Message) error {
+	if err := os.MkdirAll(d.Path, 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(d.Path, fmt.Sprintf("%s-*.eml", time.Now().Format("20060102-150405")))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(msg.Bytes(d.From)); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,39 @@
+// Package mailer sends the library's outgoing email through a pluggable
+// backend: SMTP in production, a directory of .eml files for local
+// development and an in-memory outbox for tests.
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+)
+
+// Message is a single HTML email.
+type Message struct {
+	To      []string
+	Subject string
+	HTML    string
+}
+
+// Mailer delivers messages.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Bytes renders the message as an RFC 5322 email from the given sender.
+func (m Message) Bytes(from string) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(m.To, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/html; charset=\"UTF-8\"\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(m.HTML)
+	return buf.Bytes()
+}
@@ -0,0 +1,26 @@
+package mailer
+
+import (
+	"context"
+	"sync"
+)
+
+// Memory keeps sent messages in memory. It is meant for tests.
+type Memory struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (m *Memory) Send(ctx context.Context, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// Messages returns a copy of everything sent so far.
+func (m *Memory) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.messages...)
+}
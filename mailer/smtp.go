@@ -0,0 +1,26 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"net/smtp"
+)
+
+// SMTP sends mail through an SMTP server. Username and Password are
+// optional; without them the server must accept unauthenticated relay.
+type SMTP struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+func (s *SMTP) Send(ctx context.Context, msg Message) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+	addr := fmt.Sprintf("%s:%d", s.Host, s.Port)
+	return smtp.SendMail(addr, auth, s.From, msg.To, msg.Bytes(s.From))
+}
@@ -0,0 +1,34 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+)
+
+// TemplateFile is the layout every mail of the library is rendered with. It
+// is read on each render, like the rest of the templates on disk.
+const TemplateFile = "templates/mail-template.html"
+
+// TemplateData fills TemplateFile.
+type TemplateData struct {
+	Name       string
+	Title      string
+	Message    string
+	Link       string
+	ButtonText string
+}
+
+// Render returns the HTML body of a mail.
+func Render(data TemplateData) (string, error) {
+	t, err := template.ParseFiles(TemplateFile)
+	if err != nil {
+		return "", fmt.Errorf("mail body not found: %w", err)
+	}
+
+	var body bytes.Buffer
+	if err := t.Execute(&body, data); err != nil {
+		return "", err
+	}
+	return body.String(), nil
+}
@@ -1,77 +1,187 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
 	// "database/sql"
 
+	"library/config"
 	"library/handler"
+	"library/mailer"
+	"library/migrate"
+	"library/scheduler"
+	"library/storage"
 
 	"github.com/gorilla/schema"
 	"github.com/gorilla/sessions"
-	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
 )
 
 func main() {
 
-	var createTable = `
-	CREATE TABLE IF NOT EXISTS categories (
-		id	serial,
-		name text,
-		status boolean,
-
-		primary key (id)
-	);
-	
-	CREATE TABLE IF NOT EXISTS books (
-		id	serial,
-		category_id integer,
-		book_name text,
-		author_name text,
-		details text,
-		image text,
-		status boolean,
-
-		primary Key (id)
-	);
-	
-	CREATE TABLE IF NOT EXISTS bookings (
-		id	serial,
-		user_id integer,
-		book_id integer,
-		start_time timestamp,
-		end_time timestamp,
-
-		primary Key (id)
-	);
-	
-	CREATE TABLE IF NOT EXISTS users (
-		id	serial,
-		first_name text,
-		last_name text,
-		email text,
-		password text,
-		is_verified boolean,
-
-		primary Key (id)
-	);`
-
-	db, err := sqlx.Connect("postgres", "user=postgres password=Dev@800650 dbname=library sslmode=disable")
+	configFile := flag.String("config", os.Getenv("CONFIG_FILE"), "path to a JSON config file")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-config file] [migrate up|down [steps]|status]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	db, stores, err := storage.Open(cfg.DatabaseDriver, cfg.DatabaseURL)
     if err != nil {
         log.Fatalln(err)
     }
 
-	db.MustExec(createTable)
+	migrations, err := migrate.New(db)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if flag.Arg(0) == "migrate" {
+		if err := runMigrate(migrations, flag.Args()[1:]); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+	// the server brings the schema up to date itself so a fresh checkout
+	// still starts with a single command
+	applied, err := migrations.Up(context.Background())
+	if err != nil {
+		log.Fatalln(err)
+	}
+	for _, m := range applied {
+		log.Printf("applied migration %04d_%s", m.Version, m.Name)
+	}
+
 	decoder := schema.NewDecoder()
 	decoder.IgnoreUnknownKeys(true)
 
-	store := sessions.NewCookieStore([]byte("jsowjpw38eowj4ur82jmaole0uehqpl"))
-	r := handler.New(db, decoder, store)
+	store := sessions.NewCookieStore(sessionKeyPairs(cfg.SessionKeys)...)
+	mail := newMailer(cfg.Mail)
+	r := handler.New(stores, decoder, store, cfg)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	jobs := scheduler.New()
+	jobs.Every(time.Minute, "expire reservations", scheduler.ExpireReservations(stores.Bookings))
+	jobs.Every(time.Hour, "accrue fines", scheduler.AccrueFines(stores.Ledger, storage.Money(cfg.Loans.FinePerDay)))
+	jobs.Every(time.Minute, "promote holds", scheduler.PromoteHolds(stores.Holds, stores.Outbox, time.Duration(cfg.HoldHours)*time.Hour, cfg.BaseURL))
+	jobs.Every(15*time.Second, "send mail", scheduler.SendMail(stores.Outbox, mail))
+	jobs.Every(time.Hour, "purge mail", scheduler.PurgeMail(stores.Outbox, time.Duration(cfg.Mail.RetentionDays)*24*time.Hour))
+	jobs.Start(ctx)
+
+	srv := &http.Server{Addr: cfg.ListenAddr, Handler: r}
+	// ListenAndServe returns as soon as Shutdown starts, while requests are
+	// still draining; shutdownDone tells main when they are finished, so the
+	// database is not closed under them.
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Println(err)
+		}
+	}()
 
 	log.Println("Server starting...")
-	if err:= http.ListenAndServe("127.0.0.1:3000", r); err != nil {
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		log.Fatal(err)
 	}
-}
\ No newline at end of file
+	<-shutdownDone
+	jobs.Wait()
+	if err := db.Close(); err != nil {
+		log.Println(err)
+	}
+	log.Println("Server stopped")
+}
+
+// newMailer builds the configured mail backend. "file" writes .eml files so
+// development needs no SMTP server and "memory" only keeps mail in process
+// memory.
+func newMailer(cfg config.Mail) mailer.Mailer {
+	switch cfg.Backend {
+	case "smtp":
+		return &mailer.SMTP{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.From,
+		}
+	case "memory":
+		return &mailer.Memory{}
+	default:
+		return &mailer.Dir{Path: cfg.Dir, From: cfg.From}
+	}
+}
+
+// sessionKeyPairs turns the configured keys into hash keys for the cookie
+// store. Cookies are signed, not encrypted, as before.
+func sessionKeyPairs(keys []string) [][]byte {
+	pairs := make([][]byte, 0, len(keys)*2)
+	for _, key := range keys {
+		pairs = append(pairs, []byte(key), nil)
+	}
+	return pairs
+}
+
+// runMigrate implements the migrate subcommand.
+func runMigrate(m *migrate.Runner, args []string) error {
+	ctx := context.Background()
+	if len(args) == 0 {
+		args = []string{"status"}
+	}
+	switch args[0] {
+	case "up":
+		applied, err := m.Up(ctx)
+		for _, mig := range applied {
+			fmt.Printf("applied  %04d_%s\n", mig.Version, mig.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("database is up to date")
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("migrate down: invalid step count %q", args[1])
+			}
+			steps = n
+		}
+		reverted, err := m.Down(ctx, steps)
+		for _, mig := range reverted {
+			fmt.Printf("reverted %04d_%s\n", mig.Version, mig.Name)
+		}
+		return err
+	case "status":
+		status, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range status {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%-30s %s\n", s.Version, s.Name, applied)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q, want up, down or status", args[0])
+	}
+}
@@ -0,0 +1,241 @@
+// Package migrate applies the numbered SQL migrations embedded in the binary
+// and records them in the schema_migrations table.
+//
+// Migrations live in sql/<driver>/ as NNNN_name.up.sql and
+// NNNN_name.down.sql, with the same versions for every database. Each one
+// runs in its own transaction, so a failing migration leaves the database at
+// the previous version.
+package migrate
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+//go:embed sql/postgres/*.sql sql/sqlite/*.sql
+var files embed.FS
+
+// engine holds what differs between the databases migrations run on.
+type engine struct {
+	// lock serialises migration runs across app instances. SQLite needs
+	// none: storage.Open makes every transaction take the write lock.
+	lock string
+	now  string
+}
+
+var engines = map[string]engine{
+	"postgres": {lock: `SELECT pg_advisory_xact_lock(7262351)`, now: `localtimestamp`},
+	"sqlite":   {now: `strftime('%Y-%m-%d %H:%M:%f', 'now', 'localtime')`},
+}
+
+const createVersionTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version integer PRIMARY KEY,
+	name text NOT NULL,
+	applied_at timestamp NOT NULL
+)`
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status reports whether a migration has been applied.
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Load reads the migrations in dir of fsys, sorted by version. Every
+// migration needs an up file; a missing down file makes it irreversible.
+func Load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		m := fileName.FindStringSubmatch(entry.Name())
+		if m == nil {
+			return nil, fmt.Errorf("migrate: unexpected file %s", entry.Name())
+		}
+		version, _ := strconv.Atoi(m[1])
+		body, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		}
+		if mig.Name != m[2] {
+			return nil, fmt.Errorf("migrate: version %d is used by %s and %s", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(body)
+		} else {
+			mig.Down = string(body)
+		}
+	}
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" {
+			return nil, fmt.Errorf("migrate: %04d_%s has no up migration", mig.Version, mig.Name)
+		}
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+type Runner struct {
+	db         *sqlx.DB
+	engine     engine
+	migrations []Migration
+}
+
+// New returns a runner for the migrations embedded in the binary that were
+// written for the database db is connected to.
+func New(db *sqlx.DB) (*Runner, error) {
+	e, ok := engines[db.DriverName()]
+	if !ok {
+		return nil, fmt.Errorf("migrate: no migrations for %s", db.DriverName())
+	}
+	migrations, err := Load(files, path.Join("sql", db.DriverName()))
+	if err != nil {
+		return nil, err
+	}
+	return &Runner{db: db, engine: e, migrations: migrations}, nil
+}
+
+func (r *Runner) applied(ctx context.Context, q sqlx.QueryerContext) (map[int]time.Time, error) {
+	rows := []struct {
+		Version   int       `db:"version"`
+		AppliedAt time.Time `db:"applied_at"`
+	}{}
+	if err := sqlx.SelectContext(ctx, q, &rows, `SELECT version, applied_at FROM schema_migrations`); err != nil {
+		return nil, err
+	}
+	applied := make(map[int]time.Time, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row.AppliedAt
+	}
+	return applied, nil
+}
+
+// Status lists every known migration and when it was applied.
+func (r *Runner) Status(ctx context.Context) ([]Status, error) {
+	if _, err := r.db.ExecContext(ctx, createVersionTable); err != nil {
+		return nil, err
+	}
+	applied, err := r.applied(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	status := make([]Status, len(r.migrations))
+	for i, mig := range r.migrations {
+		status[i].Migration = mig
+		if at, ok := applied[mig.Version]; ok {
+			status[i].AppliedAt = &at
+		}
+	}
+	return status, nil
+}
+
+// Up applies every pending migration in order and returns the ones it ran.
+func (r *Runner) Up(ctx context.Context) ([]Migration, error) {
+	if _, err := r.db.ExecContext(ctx, createVersionTable); err != nil {
+		return nil, err
+	}
+	var done []Migration
+	for _, mig := range r.migrations {
+		ran, err := r.step(ctx, mig, true)
+		if err != nil {
+			return done, fmt.Errorf("migrate: %04d_%s up: %w", mig.Version, mig.Name, err)
+		}
+		if ran {
+			done = append(done, mig)
+		}
+	}
+	return done, nil
+}
+
+// Down reverts the latest steps applied migrations, newest first, and
+// returns the ones it reverted.
+func (r *Runner) Down(ctx context.Context, steps int) ([]Migration, error) {
+	if _, err := r.db.ExecContext(ctx, createVersionTable); err != nil {
+		return nil, err
+	}
+	applied, err := r.applied(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	var done []Migration
+	for i := len(r.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		mig := r.migrations[i]
+		if _, ok := applied[mig.Version]; !ok {
+			continue
+		}
+		if mig.Down == "" {
+			return done, fmt.Errorf("migrate: %04d_%s cannot be reverted", mig.Version, mig.Name)
+		}
+		if _, err := r.step(ctx, mig, false); err != nil {
+			return done, fmt.Errorf("migrate: %04d_%s down: %w", mig.Version, mig.Name, err)
+		}
+		done = append(done, mig)
+	}
+	return done, nil
+}
+
+// step runs one migration in a transaction that holds the migration lock.
+// The applied check is repeated under the lock, so two instances starting
+// at once do not both run the same migration.
+func (r *Runner) step(ctx context.Context, mig Migration, up bool) (bool, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if r.engine.lock != "" {
+		if _, err := tx.ExecContext(ctx, r.engine.lock); err != nil {
+			return false, err
+		}
+	}
+	applied, err := r.applied(ctx, tx)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := applied[mig.Version]; ok == up {
+		return false, nil
+	}
+
+	if up {
+		if _, err := tx.ExecContext(ctx, mig.Up); err != nil {
+			return false, err
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations(version, name, applied_at) VALUES($1, $2, `+r.engine.now+`)`, mig.Version, mig.Name)
+	} else {
+		if _, err := tx.ExecContext(ctx, mig.Down); err != nil {
+			return false, err
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, mig.Version)
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS outbox;
+DROP TABLE IF EXISTS password_resets;
+DROP TABLE IF EXISTS api_tokens;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS booking_events;
+DROP TABLE IF EXISTS bookings;
+DROP TABLE IF EXISTS book_copies;
+DROP TABLE IF EXISTS books;
+DROP TABLE IF EXISTS categories;
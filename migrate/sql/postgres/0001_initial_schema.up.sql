@@ -0,0 +1,175 @@
+-- Baseline: the schema the server used to create on every start. Every
+-- statement is idempotent so databases created before migrations existed
+-- can adopt it without changes.
+
+CREATE TABLE IF NOT EXISTS categories (
+	id	serial,
+	name text,
+	status boolean,
+
+	primary key (id)
+);
+
+CREATE TABLE IF NOT EXISTS books (
+	id	serial,
+	category_id integer,
+	book_name text,
+	author_name text,
+	details text,
+	image text,
+	status boolean,
+
+	primary Key (id)
+);
+
+CREATE TABLE IF NOT EXISTS book_copies (
+	id	serial,
+	book_id integer,
+	barcode text UNIQUE,
+	condition text,
+	shelf_location text,
+	status boolean,
+
+	primary Key (id)
+);
+
+CREATE EXTENSION IF NOT EXISTS btree_gist;
+
+INSERT INTO book_copies (book_id, barcode, condition, shelf_location, status)
+SELECT b.id, 'BK' || lpad(b.id::text, 5, '0') || '-01', 'good', 'Unshelved', true
+FROM books b
+WHERE NOT EXISTS (SELECT 1 FROM book_copies c WHERE c.book_id = b.id);
+
+CREATE TABLE IF NOT EXISTS bookings (
+	id	serial,
+	user_id integer,
+	book_id integer,
+	copy_id integer,
+	start_time timestamp,
+	end_time timestamp,
+	status text NOT NULL DEFAULT 'reserved',
+	checked_out_at timestamp,
+	returned_at timestamp,
+	cancelled_at timestamp,
+
+	primary Key (id)
+);
+
+CREATE TABLE IF NOT EXISTS booking_events (
+	id	serial,
+	booking_id integer,
+	from_status text,
+	to_status text,
+	performed_by integer,
+	created_at timestamp,
+
+	primary Key (id)
+);
+
+ALTER TABLE bookings ADD COLUMN IF NOT EXISTS copy_id integer;
+ALTER TABLE bookings ADD COLUMN IF NOT EXISTS returned_at timestamp;
+ALTER TABLE bookings ADD COLUMN IF NOT EXISTS status text NOT NULL DEFAULT 'reserved';
+ALTER TABLE bookings ADD COLUMN IF NOT EXISTS checked_out_at timestamp;
+ALTER TABLE bookings ADD COLUMN IF NOT EXISTS cancelled_at timestamp;
+UPDATE bookings SET status = 'returned' WHERE status = 'reserved' AND returned_at IS NOT NULL;
+-- Bookings made before copies existed were for the book's only copy, the
+-- one generated above.
+UPDATE bookings bk SET copy_id = (SELECT min(c.id) FROM book_copies c WHERE c.book_id = bk.book_id)
+WHERE bk.copy_id IS NULL;
+
+-- Legacy bookings were never checked and all start out reserved. A booking
+-- without a valid window cannot be repaired and is dropped. Reservations
+-- that have ended are history and count as returned. Of the remaining
+-- reservations that overlap on a copy, the one booked first is kept and
+-- the others are cancelled, so the constraints below can be added.
+DELETE FROM bookings WHERE start_time IS NULL OR end_time IS NULL OR end_time <= start_time;
+UPDATE bookings SET status = 'returned', returned_at = end_time
+WHERE status = 'reserved' AND end_time <= localtimestamp;
+
+DO $$
+DECLARE
+	b record;
+BEGIN
+	FOR b IN SELECT id, copy_id, start_time, end_time FROM bookings
+		WHERE status IN ('reserved', 'checked_out') ORDER BY id
+	LOOP
+		IF EXISTS (SELECT 1 FROM bookings o WHERE o.copy_id = b.copy_id AND o.id < b.id
+			AND o.status IN ('reserved', 'checked_out')
+			AND o.start_time < b.end_time AND o.end_time > b.start_time) THEN
+			UPDATE bookings SET status = 'cancelled', cancelled_at = localtimestamp WHERE id = b.id;
+		END IF;
+	END LOOP;
+
+	IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'bookings_valid_window') THEN
+		ALTER TABLE bookings ADD CONSTRAINT bookings_valid_window CHECK (end_time > start_time);
+	END IF;
+	ALTER TABLE bookings DROP CONSTRAINT IF EXISTS bookings_no_overlap;
+	IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'bookings_no_active_overlap') THEN
+		ALTER TABLE bookings ADD CONSTRAINT bookings_no_active_overlap
+			EXCLUDE USING gist (copy_id WITH =, tsrange(start_time, end_time) WITH &&)
+			WHERE (status IN ('reserved', 'checked_out'));
+	END IF;
+END
+$$;
+
+CREATE TABLE IF NOT EXISTS users (
+	id	serial,
+	first_name text,
+	last_name text,
+	email text,
+	password text,
+	is_verified boolean NOT NULL DEFAULT false,
+	role text NOT NULL DEFAULT 'member',
+	session_version integer NOT NULL DEFAULT 0,
+
+	primary Key (id)
+);
+
+CREATE TABLE IF NOT EXISTS api_tokens (
+	id	serial,
+	user_id integer,
+	name text,
+	token_hash text UNIQUE,
+	hint text,
+	created_at timestamp,
+	last_used_at timestamp,
+	revoked_at timestamp,
+
+	primary Key (id)
+);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role text NOT NULL DEFAULT 'member';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS session_version integer NOT NULL DEFAULT 0;
+-- accounts created before email verification existed are trusted
+UPDATE users SET is_verified = true WHERE is_verified IS NULL;
+ALTER TABLE users ALTER COLUMN is_verified SET DEFAULT false;
+ALTER TABLE users ALTER COLUMN is_verified SET NOT NULL;
+
+CREATE TABLE IF NOT EXISTS password_resets (
+	id	serial,
+	user_id integer,
+	token_hash text UNIQUE,
+	expires_at timestamp,
+	used_at timestamp,
+	created_at timestamp,
+
+	primary Key (id)
+);
+CREATE TABLE IF NOT EXISTS outbox (
+	id	serial,
+	recipient text,
+	subject text,
+	body text,
+	status text NOT NULL DEFAULT 'pending',
+	attempts integer NOT NULL DEFAULT 0,
+	last_error text,
+	next_attempt_at timestamp,
+	created_at timestamp,
+	sent_at timestamp,
+
+	primary Key (id)
+);
+CREATE INDEX IF NOT EXISTS outbox_due ON outbox (next_attempt_at) WHERE status = 'pending';
+
+UPDATE users SET role = 'admin' WHERE id = (SELECT min(id) FROM users)
+	AND NOT EXISTS (SELECT 1 FROM users WHERE role = 'admin');
@@ -0,0 +1,16 @@
+DROP INDEX IF EXISTS booking_events_booking_id_idx;
+DROP INDEX IF EXISTS bookings_user_id_idx;
+DROP INDEX IF EXISTS bookings_book_id_idx;
+DROP INDEX IF EXISTS book_copies_book_id_idx;
+DROP INDEX IF EXISTS books_category_id_idx;
+
+ALTER TABLE password_resets DROP CONSTRAINT IF EXISTS password_resets_user_id_fkey;
+ALTER TABLE api_tokens DROP CONSTRAINT IF EXISTS api_tokens_user_id_fkey;
+ALTER TABLE booking_events DROP CONSTRAINT IF EXISTS booking_events_performed_by_fkey;
+ALTER TABLE booking_events DROP CONSTRAINT IF EXISTS booking_events_booking_id_fkey;
+ALTER TABLE bookings DROP CONSTRAINT IF EXISTS bookings_user_id_fkey;
+ALTER TABLE bookings DROP CONSTRAINT IF EXISTS bookings_copy_id_fkey;
+ALTER TABLE bookings DROP CONSTRAINT IF EXISTS bookings_book_id_fkey;
+ALTER TABLE book_copies DROP CONSTRAINT IF EXISTS book_copies_book_id_fkey;
+ALTER TABLE books DROP CONSTRAINT IF EXISTS books_category_id_fkey;
+ALTER TABLE bookings ALTER COLUMN copy_id DROP NOT NULL;
@@ -0,0 +1,55 @@
+-- Rows pointing at parents that no longer exist would stop the constraints
+-- from being added. Books whose category is gone are moved to an
+-- "Uncategorized" category; everything else that is orphaned has no
+-- meaning left and is removed.
+INSERT INTO categories (name, status)
+SELECT 'Uncategorized', true
+WHERE EXISTS (SELECT 1 FROM books b WHERE NOT EXISTS (SELECT 1 FROM categories c WHERE c.id = b.category_id));
+UPDATE books b SET category_id = (SELECT max(id) FROM categories WHERE name = 'Uncategorized')
+WHERE NOT EXISTS (SELECT 1 FROM categories c WHERE c.id = b.category_id);
+
+DELETE FROM book_copies c WHERE NOT EXISTS (SELECT 1 FROM books b WHERE b.id = c.book_id);
+
+DELETE FROM bookings bk
+WHERE NOT EXISTS (SELECT 1 FROM books b WHERE b.id = bk.book_id)
+	OR NOT EXISTS (SELECT 1 FROM users u WHERE u.id = bk.user_id)
+	OR bk.copy_id IS NULL
+	OR NOT EXISTS (SELECT 1 FROM book_copies c WHERE c.id = bk.copy_id);
+
+DELETE FROM booking_events e WHERE NOT EXISTS (SELECT 1 FROM bookings bk WHERE bk.id = e.booking_id);
+UPDATE booking_events e SET performed_by = NULL
+WHERE performed_by IS NOT NULL AND NOT EXISTS (SELECT 1 FROM users u WHERE u.id = e.performed_by);
+
+DELETE FROM api_tokens t WHERE NOT EXISTS (SELECT 1 FROM users u WHERE u.id = t.user_id);
+DELETE FROM password_resets p WHERE NOT EXISTS (SELECT 1 FROM users u WHERE u.id = p.user_id);
+
+-- 0001 gave every booking of a remaining book its copy.
+ALTER TABLE bookings ALTER COLUMN copy_id SET NOT NULL;
+
+-- A category with books cannot be deleted; the books have to be moved
+-- first. Deleting a book or copy takes its booking history with it, and
+-- the handlers refuse while any of those bookings is still active.
+ALTER TABLE books ADD CONSTRAINT books_category_id_fkey
+	FOREIGN KEY (category_id) REFERENCES categories (id) ON DELETE RESTRICT;
+ALTER TABLE book_copies ADD CONSTRAINT book_copies_book_id_fkey
+	FOREIGN KEY (book_id) REFERENCES books (id) ON DELETE CASCADE;
+ALTER TABLE bookings ADD CONSTRAINT bookings_book_id_fkey
+	FOREIGN KEY (book_id) REFERENCES books (id) ON DELETE CASCADE;
+ALTER TABLE bookings ADD CONSTRAINT bookings_copy_id_fkey
+	FOREIGN KEY (copy_id) REFERENCES book_copies (id) ON DELETE CASCADE;
+ALTER TABLE bookings ADD CONSTRAINT bookings_user_id_fkey
+	FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE RESTRICT;
+ALTER TABLE booking_events ADD CONSTRAINT booking_events_booking_id_fkey
+	FOREIGN KEY (booking_id) REFERENCES bookings (id) ON DELETE CASCADE;
+ALTER TABLE booking_events ADD CONSTRAINT booking_events_performed_by_fkey
+	FOREIGN KEY (performed_by) REFERENCES users (id) ON DELETE SET NULL;
+ALTER TABLE api_tokens ADD CONSTRAINT api_tokens_user_id_fkey
+	FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+ALTER TABLE password_resets ADD CONSTRAINT password_resets_user_id_fkey
+	FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+
+CREATE INDEX books_category_id_idx ON books (category_id);
+CREATE INDEX book_copies_book_id_idx ON book_copies (book_id);
+CREATE INDEX bookings_book_id_idx ON bookings (book_id);
+CREATE INDEX bookings_user_id_idx ON bookings (user_id);
+CREATE INDEX booking_events_booking_id_idx ON booking_events (booking_id);
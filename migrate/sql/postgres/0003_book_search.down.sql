@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS books_search_idx;
+DROP TRIGGER IF EXISTS categories_search_document ON categories;
+DROP FUNCTION IF EXISTS categories_search_document();
+DROP TRIGGER IF EXISTS books_search_document ON books;
+DROP FUNCTION IF EXISTS books_search_document();
+ALTER TABLE books DROP COLUMN IF EXISTS search;
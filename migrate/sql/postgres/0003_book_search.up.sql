@@ -0,0 +1,33 @@
+-- Full-text search over books. The document weighs the title (A) over the
+-- author (B), the details (C) and the category name (D). A trigger keeps
+-- it current; renaming a category touches its books so theirs follow.
+ALTER TABLE books ADD COLUMN search tsvector;
+
+CREATE FUNCTION books_search_document() RETURNS trigger AS $$
+BEGIN
+	NEW.search :=
+		setweight(to_tsvector('english', COALESCE(NEW.book_name, '')), 'A') ||
+		setweight(to_tsvector('english', COALESCE(NEW.author_name, '')), 'B') ||
+		setweight(to_tsvector('english', COALESCE(NEW.details, '')), 'C') ||
+		setweight(to_tsvector('english', COALESCE((SELECT name FROM categories WHERE id = NEW.category_id), '')), 'D');
+	RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER books_search_document BEFORE INSERT OR UPDATE ON books
+	FOR EACH ROW EXECUTE PROCEDURE books_search_document();
+
+CREATE FUNCTION categories_search_document() RETURNS trigger AS $$
+BEGIN
+	UPDATE books SET search = NULL WHERE category_id = NEW.id;
+	RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER categories_search_document AFTER UPDATE OF name ON categories
+	FOR EACH ROW EXECUTE PROCEDURE categories_search_document();
+
+-- the trigger fills in the existing books
+UPDATE books SET search = NULL;
+
+CREATE INDEX books_search_idx ON books USING gin (search);
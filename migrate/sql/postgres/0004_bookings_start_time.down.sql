@@ -0,0 +1 @@
+DROP INDEX IF EXISTS bookings_start_time_idx;
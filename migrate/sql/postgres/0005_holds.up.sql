@@ -0,0 +1,18 @@
+-- The waitlist. Members queue for a title in id order; when a copy is free
+-- the first waiting hold becomes ready with that copy, which is kept for
+-- them until expires_at. A member has at most one open hold per title.
+CREATE TABLE holds (
+	id	serial,
+	user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	book_id integer NOT NULL REFERENCES books (id) ON DELETE CASCADE,
+	copy_id integer REFERENCES book_copies (id) ON DELETE SET NULL,
+	status text NOT NULL DEFAULT 'waiting',
+	created_at timestamp,
+	ready_at timestamp,
+	expires_at timestamp,
+	closed_at timestamp,
+
+	primary Key (id)
+);
+CREATE UNIQUE INDEX holds_one_open ON holds (user_id, book_id) WHERE status IN ('waiting', 'ready');
+CREATE INDEX holds_queue ON holds (book_id, id) WHERE status = 'waiting';
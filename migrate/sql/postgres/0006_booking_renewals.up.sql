@@ -0,0 +1,13 @@
+-- Each renewal of a booking moves its end time; the rows keep the end times
+-- it had before and after, so the booking's history stays visible.
+CREATE TABLE booking_renewals (
+	id	serial,
+	booking_id integer NOT NULL REFERENCES bookings (id) ON DELETE CASCADE,
+	previous_end_time timestamp,
+	new_end_time timestamp,
+	renewed_by integer REFERENCES users (id) ON DELETE SET NULL,
+	created_at timestamp,
+
+	primary Key (id)
+);
+CREATE INDEX booking_renewals_booking_id ON booking_renewals (booking_id);
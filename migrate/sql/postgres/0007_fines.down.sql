@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS ledger_entries;
+ALTER TABLE bookings DROP COLUMN IF EXISTS fined_until;
+ALTER TABLE categories DROP COLUMN IF EXISTS fine_per_day;
@@ -0,0 +1,4 @@
+ALTER TABLE categories ADD COLUMN fine_per_day integer NOT NULL DEFAULT 0;
+UPDATE categories SET fine_per_day = COALESCE((SELECT p.fine_per_day FROM circulation_policies p
+	WHERE p.category_id = categories.id AND p.role = ''), 0);
+DROP TABLE IF EXISTS circulation_policies;
@@ -0,0 +1,2 @@
+-- the placeholder addresses of duplicate accounts are left as they are
+DROP INDEX IF EXISTS users_email_key;
@@ -0,0 +1,7 @@
+-- Emails identify accounts at login, so each may belong to one account only,
+-- whatever its case. Where accounts already share an email the oldest keeps
+-- it; the others keep their bookings and fines under a placeholder address
+-- that an admin can correct.
+UPDATE users SET email = 'duplicate-' || id || '+' || email
+WHERE EXISTS (SELECT 1 FROM users o WHERE lower(o.email) = lower(users.email) AND o.id < users.id);
+CREATE UNIQUE INDEX users_email_key ON users (lower(email));
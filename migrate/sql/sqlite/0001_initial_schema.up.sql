@@ -0,0 +1,122 @@
+-- Baseline for SQLite. There are no older SQLite databases to adopt, and
+-- SQLite cannot add foreign keys to an existing table, so the tables are
+-- created with the constraints the Postgres migrations arrive at.
+
+CREATE TABLE categories (
+	id integer PRIMARY KEY,
+	name text,
+	status boolean
+);
+
+CREATE TABLE books (
+	id integer PRIMARY KEY,
+	category_id integer REFERENCES categories (id) ON DELETE RESTRICT,
+	book_name text,
+	author_name text,
+	details text,
+	image text,
+	status boolean
+);
+
+CREATE TABLE book_copies (
+	id integer PRIMARY KEY,
+	book_id integer REFERENCES books (id) ON DELETE CASCADE,
+	barcode text UNIQUE,
+	condition text,
+	shelf_location text,
+	status boolean
+);
+
+CREATE TABLE users (
+	id integer PRIMARY KEY,
+	first_name text,
+	last_name text,
+	email text,
+	password text,
+	is_verified boolean NOT NULL DEFAULT false,
+	role text NOT NULL DEFAULT 'member',
+	session_version integer NOT NULL DEFAULT 0
+);
+
+-- Timestamps are stored as text in the layout storage sends, which sorts
+-- and compares in time order.
+CREATE TABLE bookings (
+	id integer PRIMARY KEY,
+	user_id integer REFERENCES users (id) ON DELETE RESTRICT,
+	book_id integer REFERENCES books (id) ON DELETE CASCADE,
+	copy_id integer NOT NULL REFERENCES book_copies (id) ON DELETE CASCADE,
+	start_time timestamp,
+	end_time timestamp,
+	status text NOT NULL DEFAULT 'reserved',
+	checked_out_at timestamp,
+	returned_at timestamp,
+	cancelled_at timestamp,
+
+	CONSTRAINT bookings_valid_window CHECK (end_time > start_time)
+);
+
+-- SQLite has no exclusion constraints. These triggers stand in for
+-- bookings_no_active_overlap and raise its name, so storage reports the
+-- same conflict on both databases.
+CREATE TRIGGER bookings_no_active_overlap_insert BEFORE INSERT ON bookings
+WHEN NEW.status IN ('reserved', 'checked_out') AND EXISTS (
+	SELECT 1 FROM bookings bk WHERE bk.copy_id = NEW.copy_id
+		AND bk.status IN ('reserved', 'checked_out')
+		AND bk.start_time < NEW.end_time AND bk.end_time > NEW.start_time
+)
+BEGIN
+	SELECT RAISE(ABORT, 'bookings_no_active_overlap');
+END;
+
+CREATE TRIGGER bookings_no_active_overlap_update BEFORE UPDATE OF copy_id, start_time, end_time, status ON bookings
+WHEN NEW.status IN ('reserved', 'checked_out') AND EXISTS (
+	SELECT 1 FROM bookings bk WHERE bk.copy_id = NEW.copy_id AND bk.id <> NEW.id
+		AND bk.status IN ('reserved', 'checked_out')
+		AND bk.start_time < NEW.end_time AND bk.end_time > NEW.start_time
+)
+BEGIN
+	SELECT RAISE(ABORT, 'bookings_no_active_overlap');
+END;
+
+CREATE TABLE booking_events (
+	id integer PRIMARY KEY,
+	booking_id integer REFERENCES bookings (id) ON DELETE CASCADE,
+	from_status text,
+	to_status text,
+	performed_by integer REFERENCES users (id) ON DELETE SET NULL,
+	created_at timestamp
+);
+
+CREATE TABLE api_tokens (
+	id integer PRIMARY KEY,
+	user_id integer REFERENCES users (id) ON DELETE CASCADE,
+	name text,
+	token_hash text UNIQUE,
+	hint text,
+	created_at timestamp,
+	last_used_at timestamp,
+	revoked_at timestamp
+);
+
+CREATE TABLE password_resets (
+	id integer PRIMARY KEY,
+	user_id integer REFERENCES users (id) ON DELETE CASCADE,
+	token_hash text UNIQUE,
+	expires_at timestamp,
+	used_at timestamp,
+	created_at timestamp
+);
+
+CREATE TABLE outbox (
+	id integer PRIMARY KEY,
+	recipient text,
+	subject text,
+	body text,
+	status text NOT NULL DEFAULT 'pending',
+	attempts integer NOT NULL DEFAULT 0,
+	last_error text,
+	next_attempt_at timestamp,
+	created_at timestamp,
+	sent_at timestamp
+);
+CREATE INDEX outbox_due ON outbox (next_attempt_at) WHERE status = 'pending';
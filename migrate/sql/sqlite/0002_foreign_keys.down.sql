@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS booking_events_booking_id_idx;
+DROP INDEX IF EXISTS bookings_user_id_idx;
+DROP INDEX IF EXISTS bookings_book_id_idx;
+DROP INDEX IF EXISTS book_copies_book_id_idx;
+DROP INDEX IF EXISTS books_category_id_idx;
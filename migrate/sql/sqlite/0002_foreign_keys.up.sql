@@ -0,0 +1,7 @@
+-- The SQLite tables are created with their foreign keys in 0001; this
+-- version only adds the indexes that go with them on Postgres.
+CREATE INDEX books_category_id_idx ON books (category_id);
+CREATE INDEX book_copies_book_id_idx ON book_copies (book_id);
+CREATE INDEX bookings_book_id_idx ON bookings (book_id);
+CREATE INDEX bookings_user_id_idx ON bookings (user_id);
+CREATE INDEX booking_events_booking_id_idx ON booking_events (booking_id);
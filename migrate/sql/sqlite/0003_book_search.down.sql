@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS book_search_category;
+DROP TRIGGER IF EXISTS book_search_delete;
+DROP TRIGGER IF EXISTS book_search_update;
+DROP TRIGGER IF EXISTS book_search_insert;
+DROP TABLE IF EXISTS book_search;
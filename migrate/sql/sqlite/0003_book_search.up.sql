@@ -0,0 +1,35 @@
+-- Full-text search over books. book_search holds one row per book, with the
+-- book's ID as rowid; storage ranks the columns title, author, details and
+-- category in that order. Triggers keep it current, including when a
+-- category is renamed.
+CREATE VIRTUAL TABLE book_search USING fts5(book_name, author_name, details, category, tokenize = 'porter unicode61');
+
+INSERT INTO book_search (rowid, book_name, author_name, details, category)
+SELECT b.id, b.book_name, b.author_name, b.details, COALESCE(c.name, '')
+FROM books b LEFT JOIN categories c ON c.id = b.category_id;
+
+CREATE TRIGGER book_search_insert AFTER INSERT ON books
+BEGIN
+	INSERT INTO book_search (rowid, book_name, author_name, details, category)
+	VALUES (NEW.id, NEW.book_name, NEW.author_name, NEW.details,
+		COALESCE((SELECT name FROM categories WHERE id = NEW.category_id), ''));
+END;
+
+CREATE TRIGGER book_search_update AFTER UPDATE ON books
+BEGIN
+	DELETE FROM book_search WHERE rowid = OLD.id;
+	INSERT INTO book_search (rowid, book_name, author_name, details, category)
+	VALUES (NEW.id, NEW.book_name, NEW.author_name, NEW.details,
+		COALESCE((SELECT name FROM categories WHERE id = NEW.category_id), ''));
+END;
+
+CREATE TRIGGER book_search_delete AFTER DELETE ON books
+BEGIN
+	DELETE FROM book_search WHERE rowid = OLD.id;
+END;
+
+CREATE TRIGGER book_search_category AFTER UPDATE OF name ON categories
+BEGIN
+	UPDATE book_search SET category = COALESCE(NEW.name, '')
+	WHERE rowid IN (SELECT id FROM books WHERE category_id = NEW.id);
+END;
@@ -0,0 +1,3 @@
+-- Booking lists are ordered by start time, newest first, and paged by
+-- cursor on (start_time, id).
+CREATE INDEX bookings_start_time_idx ON bookings (start_time, id);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS ledger_entries;
+ALTER TABLE bookings DROP COLUMN fined_until;
+ALTER TABLE categories DROP COLUMN fine_per_day;
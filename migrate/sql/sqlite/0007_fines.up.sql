@@ -0,0 +1,18 @@
+-- Overdue fines. Each category sets the fine per started overdue day, in
+-- cents. fined_until is how far a booking's overdue time has been charged.
+ALTER TABLE categories ADD COLUMN fine_per_day integer NOT NULL DEFAULT 0;
+ALTER TABLE bookings ADD COLUMN fined_until timestamp;
+
+-- A member's account. Amounts are in cents and positive: charges raise the
+-- balance, payments and waivers lower it.
+CREATE TABLE ledger_entries (
+	id integer PRIMARY KEY,
+	user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	booking_id integer REFERENCES bookings (id) ON DELETE SET NULL,
+	kind text NOT NULL,
+	amount integer NOT NULL CHECK (amount > 0),
+	note text NOT NULL DEFAULT '',
+	created_by integer REFERENCES users (id) ON DELETE SET NULL,
+	created_at timestamp
+);
+CREATE INDEX ledger_entries_user_id ON ledger_entries (user_id, id);
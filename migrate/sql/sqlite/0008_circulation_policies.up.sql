@@ -0,0 +1,20 @@
+-- Circulation policies set the lending limits for books of a category lent
+-- to members of a role. A NULL category or an empty role matches every
+-- category or role; a NULL limit is taken from the next policy that matches,
+-- down to the defaults in the configuration. Limits of 0 on loan days or
+-- items out mean no limit.
+CREATE TABLE circulation_policies (
+	id integer PRIMARY KEY,
+	category_id integer REFERENCES categories (id) ON DELETE CASCADE,
+	role text NOT NULL DEFAULT '',
+	max_loan_days integer CHECK (max_loan_days >= 0),
+	max_items_out integer CHECK (max_items_out >= 0),
+	max_renewals integer CHECK (max_renewals >= 0),
+	fine_per_day integer CHECK (fine_per_day >= 0)
+);
+CREATE UNIQUE INDEX circulation_policies_scope ON circulation_policies (COALESCE(category_id, 0), role);
+
+-- the fines set on categories become category-wide policies
+INSERT INTO circulation_policies (category_id, fine_per_day)
+SELECT id, fine_per_day FROM categories WHERE fine_per_day > 0;
+ALTER TABLE categories DROP COLUMN fine_per_day;
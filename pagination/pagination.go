@@ -0,0 +1,143 @@
+// Package pagination splits lists into pages and builds the links between
+// them. Links are relative to the site and keep the other query parameters
+// of the list, so filters and sorting survive paging.
+//
+// Page numbers every page and needs the total count. Keyset continues after
+// the last row of the previous page instead, so deep pages cost as much as
+// the first; it suits large tables such as bookings.
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"math"
+	"net/url"
+	"strconv"
+)
+
+// Link points at one numbered page.
+type Link struct {
+	URL        string
+	PageNumber int
+}
+
+// Page is one page of a list paginated by offset. The field names are the
+// ones the list templates use.
+type Page struct {
+	CurrentPage     int
+	Limit           int
+	Offset          int
+	Total           int
+	TotalPage       int
+	Paginate        []Link
+	PreviousPageURL string
+	NextPageURL     string
+}
+
+// FromQuery reads the page number from the page parameter and the page size
+// from per_page. per_page is only honoured between 1 and max; missing or
+// invalid values fall back to the first page of size rows.
+func FromQuery(query url.Values, size, max int) Page {
+	limit := perPage(query, size, max)
+	p, err := strconv.Atoi(query.Get("page"))
+	if err != nil || p < 1 {
+		p = 1
+	}
+	return Page{CurrentPage: p, Limit: limit, Offset: (p - 1) * limit}
+}
+
+// Build fills in the total and the links of the page. path is the URL path
+// of the list and query its parameters; page is replaced in every link.
+func (p *Page) Build(path string, query url.Values, total int) {
+	p.Total = total
+	p.TotalPage = int(math.Ceil(float64(total) / float64(p.Limit)))
+	p.Paginate = make([]Link, p.TotalPage)
+	for i := range p.Paginate {
+		p.Paginate[i] = Link{URL: link(path, query, "page", strconv.Itoa(i+1)), PageNumber: i + 1}
+	}
+	p.PreviousPageURL, p.NextPageURL = "", ""
+	if p.CurrentPage > 1 && p.CurrentPage <= p.TotalPage {
+		p.PreviousPageURL = p.Paginate[p.CurrentPage-2].URL
+	}
+	if p.CurrentPage < p.TotalPage {
+		p.NextPageURL = p.Paginate[p.CurrentPage].URL
+	}
+}
+
+// Keyset is one page of a list paginated by cursor. A cursor is an opaque
+// string holding the sort key of the last row of the previous page.
+type Keyset struct {
+	Limit int
+	// Cursor is where this page starts, empty for the first page.
+	Cursor string
+	// NextCursor is where the next page starts, empty on the last page.
+	NextCursor  string
+	NextPageURL string
+	// FirstPageURL leads back to the start of the list, empty on the first
+	// page. A cursor only points forward, so there is no previous page.
+	FirstPageURL string
+}
+
+// KeysetFromQuery reads the cursor from the cursor parameter and the page
+// size from per_page, like FromQuery.
+func KeysetFromQuery(query url.Values, size, max int) Keyset {
+	return Keyset{Limit: perPage(query, size, max), Cursor: query.Get("cursor")}
+}
+
+// Decode reads the page's cursor into key. It reports false on the first
+// page; a cursor that cannot be read is an error.
+func (k *Keyset) Decode(key interface{}) (bool, error) {
+	if k.Cursor == "" {
+		return false, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(k.Cursor)
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(b, key)
+}
+
+// Build sets the next cursor from next, the sort key of the last row, and
+// the link to the next page. Both stay empty when next is nil.
+func (k *Keyset) Build(path string, query url.Values, next interface{}) error {
+	k.NextCursor, k.NextPageURL, k.FirstPageURL = "", "", ""
+	if k.Cursor != "" {
+		k.FirstPageURL = link(path, query, "cursor", "")
+	}
+	if next == nil {
+		return nil
+	}
+	b, err := json.Marshal(next)
+	if err != nil {
+		return err
+	}
+	k.NextCursor = base64.RawURLEncoding.EncodeToString(b)
+	k.NextPageURL = link(path, query, "cursor", k.NextCursor)
+	return nil
+}
+
+func perPage(query url.Values, size, max int) int {
+	n, err := strconv.Atoi(query.Get("per_page"))
+	if err != nil || n < 1 || n > max {
+		return size
+	}
+	return n
+}
+
+// link returns path with query, where key is set to value, or dropped when
+// value is empty. Empty parameters are left out.
+func link(path string, query url.Values, key, value string) string {
+	q := url.Values{}
+	for k := range query {
+		if v := query.Get(k); v != "" && k != key {
+			q.Set(k, v)
+		}
+	}
+	if value != "" {
+		q.Set(key, value)
+	}
+	if len(q) == 0 {
+		return path
+	}
+	return path + "?" + q.Encode()
+}
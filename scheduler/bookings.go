@@ -0,0 +1,24 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+
+	"library/storage"
+)
+
+// ExpireReservations marks reservations whose window ended without the copy
+// being checked out as no-shows, which releases the copy they were holding.
+// Loans that are checked out stay open until the copy is checked in.
+func ExpireReservations(bookings storage.BookingStore) func(context.Context) error {
+	return func(ctx context.Context) error {
+		n, err := bookings.ExpireReservations(ctx)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			log.Printf("scheduler: expired %d reservations", n)
+		}
+		return nil
+	}
+}
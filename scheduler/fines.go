@@ -0,0 +1,24 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+
+	"library/storage"
+)
+
+// AccrueFines charges members for each started day their loans are
+// overdue, at the fine of the circulation policy for the loan or else at
+// defaultFine.
+func AccrueFines(ledger storage.LedgerStore, defaultFine storage.Money) func(context.Context) error {
+	return func(ctx context.Context) error {
+		n, err := ledger.AccrueFines(ctx, defaultFine)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			log.Printf("scheduler: charged overdue fines on %d loans", n)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,47 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"library/mailer"
+	"library/storage"
+)
+
+// PromoteHolds passes free copies to the waitlists. Holds that were not
+// booked within period expire, and each free copy is kept for the first
+// member waiting for its title, who is mailed a link to book it. baseURL is
+// the public URL of the site.
+func PromoteHolds(holds storage.HoldStore, outbox storage.OutboxStore, period time.Duration, baseURL string) func(context.Context) error {
+	return func(ctx context.Context) error {
+		ready, err := holds.PromoteHolds(ctx, period)
+		if err != nil {
+			return err
+		}
+		for _, hold := range ready {
+			const subject = "Your Hold Is Ready"
+			body, err := mailer.Render(mailer.TemplateData{
+				Name:  hold.UserName,
+				Title: subject,
+				Message: fmt.Sprintf("A copy of %s is being kept for you until %s. Book it before then or it passes to the next person on the waitlist.",
+					hold.BookName, hold.ExpiresAt.Format("Mon Jan _2 2006 15:04")),
+				Link:       fmt.Sprintf("%s/bookings/%d/create", baseURL, hold.BookID),
+				ButtonText: "Book Now",
+			})
+			if err == nil {
+				err = outbox.QueueMail(ctx, hold.UserEmail, subject, body)
+			}
+			// the hold stands without the mail; it is listed on the
+			// member's bookings page as well
+			if err != nil {
+				log.Printf("scheduler: hold %d: %v", hold.ID, err)
+			}
+		}
+		if len(ready) > 0 {
+			log.Printf("scheduler: %d holds ready", len(ready))
+		}
+		return nil
+	}
+}
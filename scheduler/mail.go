@@ -0,0 +1,79 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"library/mailer"
+	"library/storage"
+)
+
+// maxMailAttempts is how often a mail is tried before it is marked failed
+// and left for an admin to retry from the outbox page.
+const maxMailAttempts = 8
+
+// mailBatch caps how many mails one run sends.
+const mailBatch = 20
+
+// mailLease is how long a claimed mail is kept from other runs of the job
+// while it is being sent.
+const mailLease = 5 * time.Minute
+
+// mailBackoff is the wait before the next attempt after attempts failures:
+// one minute, doubling each time, capped at six hours.
+func mailBackoff(attempts int) time.Duration {
+	d := time.Minute << (attempts - 1)
+	if attempts > 10 || d > 6*time.Hour {
+		return 6 * time.Hour
+	}
+	return d
+}
+
+// SendMail delivers due mail from the outbox. Mails are claimed before they
+// are sent, so several app instances can run the job without sending a mail
+// twice.
+func SendMail(outbox storage.OutboxStore, m mailer.Mailer) func(context.Context) error {
+	return func(ctx context.Context) error {
+		mails, err := outbox.ClaimMails(ctx, mailBatch, mailLease)
+		if err != nil {
+			return err
+		}
+
+		for _, mail := range mails {
+			msg := mailer.Message{To: []string{mail.Recipient}, Subject: mail.Subject, HTML: mail.Body}
+			if err := m.Send(ctx, msg); err != nil {
+				attempts := mail.Attempts + 1
+				var retryAt *time.Time
+				if attempts < maxMailAttempts {
+					t := storage.WallClock().Add(mailBackoff(attempts))
+					retryAt = &t
+				}
+				log.Printf("scheduler: mail %d to %s failed (attempt %d): %v", mail.ID, mail.Recipient, attempts, err)
+				if err := outbox.MarkMailFailed(ctx, mail.ID, err.Error(), retryAt); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := outbox.MarkMailSent(ctx, mail.ID); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// PurgeMail deletes sent and failed mails once they are older than
+// retention, so the outbox does not grow forever.
+func PurgeMail(outbox storage.OutboxStore, retention time.Duration) func(context.Context) error {
+	return func(ctx context.Context) error {
+		n, err := outbox.PurgeMails(ctx, storage.WallClock().Add(-retention))
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			log.Printf("scheduler: purged %d mails from the outbox", n)
+		}
+		return nil
+	}
+}
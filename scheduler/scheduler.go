@@ -0,0 +1,57 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Scheduler runs background jobs on fixed intervals until the context it
+// was started with is cancelled.
+type Scheduler struct {
+	jobs []job
+	wg   sync.WaitGroup
+}
+
+type job struct {
+	name     string
+	interval time.Duration
+	run      func(context.Context) error
+}
+
+func New() *Scheduler {
+	return &Scheduler{}
+}
+
+// Every registers run to be called once at start up and then every interval.
+func (s *Scheduler) Every(interval time.Duration, name string, run func(context.Context) error) {
+	s.jobs = append(s.jobs, job{name: name, interval: interval, run: run})
+}
+
+// Start launches every registered job in its own goroutine.
+func (s *Scheduler) Start(ctx context.Context) {
+	for _, j := range s.jobs {
+		s.wg.Add(1)
+		go func(j job) {
+			defer s.wg.Done()
+			ticker := time.NewTicker(j.interval)
+			defer ticker.Stop()
+			for {
+				if err := j.run(ctx); err != nil && ctx.Err() == nil {
+					log.Printf("scheduler: %s: %v", j.name, err)
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}(j)
+	}
+}
+
+// Wait blocks until every job has returned after the context is cancelled.
+func (s *Scheduler) Wait() {
+	s.wg.Wait()
+}
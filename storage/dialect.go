@@ -0,0 +1,220 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"html"
+	"html/template"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+)
+
+// The queries of this package are written for Postgres. A dialect rewrites
+// them, and their time arguments, for the engine the store runs on. Both
+// drivers accept $n placeholders, so those are left alone.
+type dialect struct {
+	// replacer rewrites Postgres-only syntax. It is nil for Postgres.
+	replacer *strings.Replacer
+	// timeLayout is how time arguments are sent, or empty to send them as
+	// they are. SQLite compares timestamps as text, so every value has to
+	// use the layout of its now expression.
+	timeLayout string
+	search     textSearch
+}
+
+// textSearch is how a dialect runs full-text search over books aliased as
+// b. The fragments take the prepared query as $1.
+type textSearch struct {
+	prepare func(query string) string
+	join    string
+	match   string
+	// order sorts the best match first.
+	order string
+	// snippet marks matched words with snippetStart and snippetStop.
+	snippet string
+}
+
+var postgresDialect = dialect{
+	search: textSearch{
+		prepare: strings.TrimSpace,
+		join:    `CROSS JOIN websearch_to_tsquery('english', $1) q`,
+		match:   `b.search @@ q`,
+		order:   `ts_rank(b.search, q) DESC`,
+		snippet: `ts_headline('english', COALESCE(b.details, ''), q,
+			'StartSel=' || chr(2) || ', StopSel=' || chr(3) || ', MinWords=12, MaxWords=30')`,
+	},
+}
+
+// sqliteNow matches the wall clock values Postgres gets from localtimestamp.
+const sqliteNow = `strftime('%Y-%m-%d %H:%M:%f', 'now', 'localtime')`
+
+var sqliteDialect = dialect{
+	replacer: strings.NewReplacer(
+		"localtimestamp", sqliteNow,
+		// SQLite's LIKE already ignores case for ASCII.
+		"ILIKE", "LIKE",
+		// SQLite transactions lock the whole database for writing as they
+		// begin (see Open), so there are no rows to lock.
+		" FOR UPDATE SKIP LOCKED", "",
+		" FOR UPDATE", "",
+	),
+	timeLayout: "2006-01-02 15:04:05.000",
+	search: textSearch{
+		prepare: sqliteSearchTerms,
+		join:    `JOIN book_search ON book_search.rowid = b.id`,
+		match:   `book_search MATCH $1`,
+		// bm25 is lower for better matches; the weights follow the columns
+		// of book_search: title, author, details, category.
+		order:   `bm25(book_search, 8.0, 4.0, 1.0, 0.5)`,
+		snippet: `snippet(book_search, 2, char(2), char(3), '...', 24)`,
+	},
+}
+
+// sqliteSearchTerms turns a query into an FTS5 expression that needs every
+// word, so quotes and operators typed into the search box cannot break it.
+func sqliteSearchTerms(query string) string {
+	words := strings.FieldsFunc(query, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for i, w := range words {
+		words[i] = `"` + w + `"`
+	}
+	return strings.Join(words, " ")
+}
+
+// Search snippets mark matches with control characters that cannot come
+// from HTML, so the rest of the snippet can be escaped.
+const (
+	snippetStart = "\x02"
+	snippetStop  = "\x03"
+)
+
+var snippetMarks = strings.NewReplacer(snippetStart, "<mark>", snippetStop, "</mark>")
+
+// highlight turns a snippet from the database into HTML. The snippet is
+// escaped here, so templates can show the result as it is.
+func highlight(snippet template.HTML) template.HTML {
+	return template.HTML(snippetMarks.Replace(html.EscapeString(string(snippet))))
+}
+
+func (d dialect) query(query string) string {
+	if d.replacer == nil {
+		return query
+	}
+	return d.replacer.Replace(query)
+}
+
+func (d dialect) args(args []interface{}) []interface{} {
+	if d.timeLayout == "" {
+		return args
+	}
+	converted := make([]interface{}, len(args))
+	for i, arg := range args {
+		switch t := arg.(type) {
+		case time.Time:
+			converted[i] = t.Format(d.timeLayout)
+		case *time.Time:
+			if t != nil {
+				converted[i] = t.Format(d.timeLayout)
+			}
+		default:
+			converted[i] = arg
+		}
+	}
+	return converted
+}
+
+// runner runs queries through the dialect. The stores use it in place of
+// sqlx.DB and sqlx.Tx.
+type runner struct {
+	ext sqlx.ExtContext
+	d   dialect
+}
+
+func (r runner) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return sqlx.GetContext(ctx, r.ext, dest, r.d.query(query), r.d.args(args)...)
+}
+
+func (r runner) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return sqlx.SelectContext(ctx, r.ext, dest, r.d.query(query), r.d.args(args)...)
+}
+
+func (r runner) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return r.ext.ExecContext(ctx, r.d.query(query), r.d.args(args)...)
+}
+
+func (r runner) QueryRowxContext(ctx context.Context, query string, args ...interface{}) *sqlx.Row {
+	return r.ext.QueryRowxContext(ctx, r.d.query(query), r.d.args(args)...)
+}
+
+type database struct {
+	runner
+	db *sqlx.DB
+}
+
+func (d database) BeginTxx(ctx context.Context, opts *sql.TxOptions) (transaction, error) {
+	tx, err := d.db.BeginTxx(ctx, opts)
+	if err != nil {
+		return transaction{}, err
+	}
+	return transaction{runner{tx, d.d}, tx}, nil
+}
+
+type transaction struct {
+	runner
+	tx *sqlx.Tx
+}
+
+func (t transaction) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t transaction) Rollback() error {
+	return t.tx.Rollback()
+}
+
+// SQLite extended result codes, see https://www.sqlite.org/rescode.html.
+const (
+	sqliteConstraintForeignKey = 787
+	sqliteConstraintUnique     = 2067
+	sqliteConstraintTrigger    = 1811
+)
+
+// isExclusionViolation reports a booking that overlaps an active one on the
+// same copy. Postgres enforces that with an exclusion constraint, SQLite
+// with triggers that raise the constraint's name.
+func isExclusionViolation(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23P01"
+	}
+	var liteErr *sqlite.Error
+	return errors.As(err, &liteErr) && liteErr.Code() == sqliteConstraintTrigger &&
+		strings.Contains(liteErr.Error(), "bookings_no_active_overlap")
+}
+
+func isForeignKeyViolation(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23503"
+	}
+	// SQLite reports ON DELETE RESTRICT through its trigger code.
+	var liteErr *sqlite.Error
+	return errors.As(err, &liteErr) && (liteErr.Code() == sqliteConstraintForeignKey ||
+		liteErr.Code() == sqliteConstraintTrigger && strings.Contains(liteErr.Error(), "FOREIGN KEY"))
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505"
+	}
+	var liteErr *sqlite.Error
+	return errors.As(err, &liteErr) && liteErr.Code() == sqliteConstraintUnique
+}
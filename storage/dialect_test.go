@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSQLiteDialectQuery(t *testing.T) {
+	tests := []struct {
+		query, want string
+	}{
+		{
+			`UPDATE holds SET closed_at = localtimestamp WHERE id = $1`,
+			`UPDATE holds SET closed_at = ` + sqliteNow + ` WHERE id = $1`,
+		},
+		{
+			`SELECT id FROM users WHERE email ILIKE '%' || $1 || '%'`,
+			`SELECT id FROM users WHERE email LIKE '%' || $1 || '%'`,
+		},
+		{
+			`SELECT status FROM bookings WHERE id = $1 FOR UPDATE`,
+			`SELECT status FROM bookings WHERE id = $1`,
+		},
+		{
+			`SELECT * FROM outbox ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED`,
+			`SELECT * FROM outbox ORDER BY id LIMIT $1`,
+		},
+		{
+			`INSERT INTO holds(user_id, created_at) VALUES($1, localtimestamp) RETURNING id`,
+			`INSERT INTO holds(user_id, created_at) VALUES($1, ` + sqliteNow + `) RETURNING id`,
+		},
+	}
+	for _, test := range tests {
+		if got := sqliteDialect.query(test.query); got != test.want {
+			t.Errorf("query(%q) = %q, want %q", test.query, got, test.want)
+		}
+		if got := postgresDialect.query(test.query); got != test.query {
+			t.Errorf("Postgres changed %q to %q", test.query, got)
+		}
+	}
+}
+
+func TestSQLiteDialectArgs(t *testing.T) {
+	at := time.Date(2024, 3, 5, 9, 7, 2, 250e6, time.UTC)
+	var none *time.Time
+	got := sqliteDialect.args([]interface{}{at, &at, none, 7, "x"})
+	want := []interface{}{"2024-03-05 09:07:02.250", "2024-03-05 09:07:02.250", nil, 7, "x"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("argument %d: got %#v, want %#v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	got := highlight("<b>" + snippetStart + "dune" + snippetStop + "</b> & co")
+	if want := "&lt;b&gt;<mark>dune</mark>&lt;/b&gt; &amp; co"; string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,346 @@
+package storage
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Money is an amount in cents. It is shown and entered with two decimals.
+type Money int
+
+func (m Money) String() string {
+	sign := ""
+	if m < 0 {
+		sign, m = "-", -m
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, m/100, m%100)
+}
+
+// UnmarshalText reads an amount such as "2", "2.5" or "2.50". An empty
+// amount is zero.
+func (m *Money) UnmarshalText(text []byte) error {
+	s := strings.TrimSpace(string(text))
+	if s == "" {
+		*m = 0
+		return nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+		return fmt.Errorf("%q is not an amount", text)
+	}
+	*m = Money(math.Round(f * 100))
+	return nil
+}
+
+type Category struct {
+	ID     int    `db:"id"`
+	Name   string `db:"name"`
+	Status bool   `db:"status"`
+}
+
+type Book struct {
+	ID          int    `db:"id"`
+	Category_id int    `db:"category_id"`
+	Book_name   string `db:"book_name"`
+	AuthorName  string `db:"author_name"`
+	Details     string `db:"details"`
+	Image       string `db:"image"`
+	Status      bool   `db:"status"`
+	// filled in by the store
+	Cat_name        string `db:"cat_name"`
+	TotalCopies     int    `db:"total_copies"`
+	AvailableCopies int    `db:"available_copies"`
+	// Snippet is filled in by SearchBooks: HTML of the details around the
+	// match, with the matched words in <mark>.
+	Snippet template.HTML `db:"snippet"`
+	// number of copies to generate when the book is created
+	Copies int `db:"-"`
+}
+
+// BookSearch narrows and orders a list of books. Zero fields do not filter.
+type BookSearch struct {
+	// Query is matched against the title, author, details and category
+	// name, in that order of weight.
+	Query      string `schema:"search"`
+	CategoryID int    `schema:"category"`
+	// Author matches part of the author's name.
+	Author string `schema:"author"`
+	// Available keeps only active books with a copy free right now.
+	Available bool `schema:"available"`
+	// Sort is "name", "author", "category", "added" or "available". Other
+	// values sort by best match when there is a query and by name
+	// otherwise.
+	Sort string `schema:"sort"`
+	// Desc reverses the sort. Best match order cannot be reversed.
+	Desc bool `schema:"desc"`
+}
+
+// BookCopy is a single physical item of a title. A title can have many
+// copies, each with its own barcode, condition and shelf location.
+type BookCopy struct {
+	ID            int    `db:"id"`
+	BookID        int    `db:"book_id"`
+	Barcode       string `db:"barcode"`
+	Condition     string `db:"condition"`
+	ShelfLocation string `db:"shelf_location"`
+	Status        bool   `db:"status"`
+	Available     bool   `db:"available"`
+}
+
+// Booking lifecycle. A booking starts reserved, becomes checked out when the
+// copy is handed over and ends returned, cancelled or as a no-show.
+const (
+	BookingReserved   = "reserved"
+	BookingCheckedOut = "checked_out"
+	BookingReturned   = "returned"
+	BookingCancelled  = "cancelled"
+	BookingNoShow     = "no_show"
+)
+
+var bookingTransitions = map[string][]string{
+	BookingReserved:   {BookingCheckedOut, BookingCancelled, BookingNoShow},
+	BookingCheckedOut: {BookingReturned},
+}
+
+func CanTransition(from, to string) bool {
+	for _, next := range bookingTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+type Booking struct {
+	ID           int        `db:"id"`
+	UserID       int        `db:"user_id"`
+	BookID       int        `db:"book_id"`
+	CopyID       int        `db:"copy_id"`
+	StartTime    time.Time  `db:"start_time"`
+	EndTime      time.Time  `db:"end_time"`
+	Status       string     `db:"status"`
+	CheckedOutAt *time.Time `db:"checked_out_at"`
+	ReturnedAt   *time.Time `db:"returned_at"`
+	CancelledAt  *time.Time `db:"cancelled_at"`
+	// FinedUntil is how far the overdue time of the booking was charged.
+	FinedUntil *time.Time `db:"fined_until"`
+	// filled in by the store
+	BookName  string `db:"book_name"`
+	Barcode   string `db:"barcode"`
+	UserEmail string `db:"user_email"`
+	// Renewals counts how often the end time was moved by a renewal.
+	Renewals int `db:"renewals"`
+	// form values and display times, in the layout the page uses
+	Start_time string `db:"-"`
+	End_time   string `db:"-"`
+}
+
+func (b Booking) CanCheckOut() bool {
+	return CanTransition(b.Status, BookingCheckedOut)
+}
+
+func (b Booking) CanCheckIn() bool {
+	return CanTransition(b.Status, BookingReturned)
+}
+
+func (b Booking) CanCancel() bool {
+	return CanTransition(b.Status, BookingCancelled)
+}
+
+// CanRenew reports whether the booking is still active. Whether a renewal
+// goes through also depends on the limits checked by the store.
+func (b Booking) CanRenew() bool {
+	return b.Status == BookingReserved || b.Status == BookingCheckedOut
+}
+
+// BookingFilter narrows a list of bookings. Zero fields do not filter.
+type BookingFilter struct {
+	// UserID restricts the list to one user's bookings.
+	UserID int `schema:"-"`
+	// User matches part of the user's email.
+	User   string
+	BookID int
+	// From and To are dates (2006-01-02); every booking that overlaps the
+	// days in between matches.
+	From   string
+	To     string
+	Status string
+}
+
+// BookingCursor is a position in a list of bookings, which are ordered by
+// start time, newest first. It holds the last booking of the previous page.
+type BookingCursor struct {
+	StartTime time.Time
+	ID        int
+}
+
+// Hold lifecycle. A hold waits in its title's queue until a copy is free,
+// is then ready with the copy kept for its member, and ends fulfilled when
+// they book it, expired when they do not in time, or cancelled.
+const (
+	HoldWaiting   = "waiting"
+	HoldReady     = "ready"
+	HoldFulfilled = "fulfilled"
+	HoldExpired   = "expired"
+	HoldCancelled = "cancelled"
+)
+
+// Hold is a member's place on the waitlist of a title.
+type Hold struct {
+	ID        int        `db:"id"`
+	UserID    int        `db:"user_id"`
+	BookID    int        `db:"book_id"`
+	CopyID    *int       `db:"copy_id"`
+	Status    string     `db:"status"`
+	CreatedAt time.Time  `db:"created_at"`
+	ReadyAt   *time.Time `db:"ready_at"`
+	ExpiresAt *time.Time `db:"expires_at"`
+	ClosedAt  *time.Time `db:"closed_at"`
+	// filled in by the store
+	BookName  string `db:"book_name"`
+	UserEmail string `db:"user_email"`
+	UserName  string `db:"user_name"`
+	// Position is the place in the queue of a waiting hold, from 1.
+	Position int `db:"position"`
+}
+
+func (h Hold) CanCancel() bool {
+	return h.Status == HoldWaiting || h.Status == HoldReady
+}
+
+// CirculationPolicy sets lending limits for books of a category lent to
+// members of a role. A nil CategoryID or an empty Role matches every
+// category or role. Nil limits are left to the less specific policies that
+// match, and in the end to the defaults passed to Limits.Apply.
+type CirculationPolicy struct {
+	ID          int    `db:"id"`
+	CategoryID  *int   `db:"category_id"`
+	Role        string `db:"role"`
+	MaxLoanDays *int   `db:"max_loan_days"`
+	MaxItemsOut *int   `db:"max_items_out"`
+	MaxRenewals *int   `db:"max_renewals"`
+	FinePerDay  *Money `db:"fine_per_day"`
+	// filled in by the store
+	CategoryName string `db:"category_name"`
+}
+
+// Limits are the lending limits that apply to one loan. A MaxLoanDays or
+// MaxItemsOut of 0 means no limit.
+type Limits struct {
+	MaxLoanDays int
+	MaxItemsOut int
+	MaxRenewals int
+	// FinePerDay is charged for each started day the loan is overdue.
+	FinePerDay Money
+}
+
+// Apply returns l with the limits set by policies, which are ordered most
+// specific first, so the most specific policy setting a limit wins.
+func (l Limits) Apply(policies []CirculationPolicy) Limits {
+	for i := len(policies) - 1; i >= 0; i-- {
+		p := policies[i]
+		if p.MaxLoanDays != nil {
+			l.MaxLoanDays = *p.MaxLoanDays
+		}
+		if p.MaxItemsOut != nil {
+			l.MaxItemsOut = *p.MaxItemsOut
+		}
+		if p.MaxRenewals != nil {
+			l.MaxRenewals = *p.MaxRenewals
+		}
+		if p.FinePerDay != nil {
+			l.FinePerDay = *p.FinePerDay
+		}
+	}
+	return l
+}
+
+// Ledger entry kinds. Charges raise a member's balance; payments and waivers
+// lower it.
+const (
+	LedgerCharge  = "charge"
+	LedgerPayment = "payment"
+	LedgerWaiver  = "waiver"
+)
+
+// LedgerEntry is a line on a member's account. Amount is always positive;
+// Kind decides which way it moves the balance.
+type LedgerEntry struct {
+	ID        int       `db:"id"`
+	UserID    int       `db:"user_id"`
+	BookingID *int      `db:"booking_id"`
+	Kind      string    `db:"kind"`
+	Amount    Money     `db:"amount"`
+	Note      string    `db:"note"`
+	CreatedBy *int      `db:"created_by"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+const (
+	RoleAdmin     = "admin"
+	RoleLibrarian = "librarian"
+	RoleMember    = "member"
+)
+
+type User struct {
+	ID              int    `db:"id"`
+	FirstName       string `db:"first_name"`
+	LastName        string `db:"last_name"`
+	Email           string `db:"email"`
+	Password        string `db:"password"`
+	ConfirmPassword string `db:"-"`
+	IsVerified      bool   `db:"is_verified"`
+	Role            string `db:"role"`
+	SessionVersion  int    `db:"session_version"`
+}
+
+// APIToken is a personal access token. Only the SHA-256 hash of the token
+// is stored; the plain value is shown once when it is created.
+type APIToken struct {
+	ID         int        `db:"id"`
+	UserID     int        `db:"user_id"`
+	Name       string     `db:"name"`
+	TokenHash  string     `db:"token_hash"`
+	Hint       string     `db:"hint"`
+	CreatedAt  time.Time  `db:"created_at"`
+	LastUsedAt *time.Time `db:"last_used_at"`
+	RevokedAt  *time.Time `db:"revoked_at"`
+}
+
+type PasswordReset struct {
+	ID        int        `db:"id"`
+	UserID    int        `db:"user_id"`
+	TokenHash string     `db:"token_hash"`
+	ExpiresAt time.Time  `db:"expires_at"`
+	UsedAt    *time.Time `db:"used_at"`
+	CreatedAt time.Time  `db:"created_at"`
+}
+
+const (
+	OutboxPending = "pending"
+	OutboxSent    = "sent"
+	OutboxFailed  = "failed"
+)
+
+// OutboxMail is a queued email. The scheduler's mail job moves it from
+// pending to sent, or to failed once it runs out of attempts.
+type OutboxMail struct {
+	ID            int        `db:"id"`
+	Recipient     string     `db:"recipient"`
+	Subject       string     `db:"subject"`
+	Body          string     `db:"body"`
+	Status        string     `db:"status"`
+	Attempts      int        `db:"attempts"`
+	LastError     *string    `db:"last_error"`
+	NextAttemptAt *time.Time `db:"next_attempt_at"`
+	CreatedAt     time.Time  `db:"created_at"`
+	SentAt        *time.Time `db:"sent_at"`
+}
+
+func (m OutboxMail) CanRetry() bool {
+	return m.Status != OutboxSent
+}
@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+// sqlStore implements every store on a SQL database.
+type sqlStore struct {
+	db database
+}
+
+// NewPostgres returns the stores backed by a Postgres database.
+func NewPostgres(db *sqlx.DB) Stores {
+	return newStores(db, postgresDialect)
+}
+
+// NewSQLite returns the stores backed by a SQLite database opened with Open.
+func NewSQLite(db *sqlx.DB) Stores {
+	return newStores(db, sqliteDialect)
+}
+
+func newStores(db *sqlx.DB, d dialect) Stores {
+	s := &sqlStore{db: database{runner{db, d}, db}}
+	return Stores{
+		Books:      s,
+		Categories: s,
+		Bookings:   s,
+		Users:      s,
+		Outbox:     s,
+		Holds:      s,
+		Ledger:     s,
+		Policies:   s,
+	}
+}
+
+// Open connects to the database and returns the stores backed by it. driver
+// is "postgres", with url a connection string, or "sqlite", with url the
+// path of the database file (or ":memory:").
+func Open(driver, url string) (*sqlx.DB, Stores, error) {
+	switch driver {
+	case "postgres":
+		db, err := sqlx.Connect("postgres", url)
+		if err != nil {
+			return nil, Stores{}, err
+		}
+		return db, NewPostgres(db), nil
+	case "sqlite":
+		sep := "?"
+		if strings.Contains(url, "?") {
+			sep = "&"
+		}
+		// _txlock=immediate takes the write lock when a transaction begins,
+		// which stands in for Postgres' row locks.
+		dsn := "file:" + url + sep + "_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate"
+		db, err := sqlx.Connect("sqlite", dsn)
+		if err != nil {
+			return nil, Stores{}, err
+		}
+		// SQLite has a single writer anyway. One connection queues writers
+		// in the pool instead of failing them, and keeps ":memory:" to one
+		// database.
+		db.SetMaxOpenConns(1)
+		return db, NewSQLite(db), nil
+	}
+	return nil, Stores{}, fmt.Errorf("unknown database driver %q", driver)
+}
+
+// activeBookingStatuses hold a copy and take part in overlap checks.
+const activeBookingStatuses = `('reserved', 'checked_out')`
+
+// availableCopyFilter matches copies that are in circulation and not held
+// right now, either by a loan that has not been checked in or by a
+// reservation whose window is running, or kept for a member on the
+// waitlist. Future reservations do not count.
+const availableCopyFilter = `c.status = true AND NOT EXISTS (
+	SELECT 1 FROM bookings bk WHERE bk.copy_id = c.id AND (bk.status = 'checked_out'
+		OR (bk.status = 'reserved' AND bk.start_time <= localtimestamp AND bk.end_time > localtimestamp))
+) AND NOT EXISTS (SELECT 1 FROM holds h WHERE h.copy_id = c.id AND h.status = 'ready')`
+
+// notFound turns sql.ErrNoRows into ErrNotFound.
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// rowsAffected returns ErrNotFound when an update or delete matched nothing.
+func rowsAffected(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
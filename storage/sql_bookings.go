@@ -0,0 +1,308 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// selectBooking adds the book name, copy barcode, user email and number of
+// renewals to each bookings row.
+const selectBooking = `SELECT bk.*, COALESCE(b.book_name, '') AS book_name,
+	COALESCE(c.barcode, '') AS barcode, COALESCE(u.email, '') AS user_email,
+	(SELECT count(*) FROM booking_renewals r WHERE r.booking_id = bk.id) AS renewals
+	FROM bookings bk
+	LEFT JOIN books b ON b.id = bk.book_id
+	LEFT JOIN book_copies c ON c.id = bk.copy_id
+	LEFT JOIN users u ON u.id = bk.user_id`
+
+// overlappingBooking matches bookings on copy c that collide with the window
+// [$2, $3). It mirrors the bookings_no_active_overlap constraint.
+const overlappingBooking = `SELECT 1 FROM bookings bk WHERE bk.copy_id = c.id
+	AND bk.status IN ` + activeBookingStatuses + `
+	AND bk.start_time < $3 AND bk.end_time > $2`
+
+// heldForOther matches a ready hold that keeps copy c for someone other than
+// user $4 past the start of the window.
+const heldForOther = `SELECT 1 FROM holds h WHERE h.copy_id = c.id AND h.status = 'ready'
+	AND h.user_id <> $4 AND h.expires_at > $2`
+
+// Reserve picks the first free copy, preferring one kept for the user by a
+// hold, and inserts the booking. Booking the title closes the user's open
+// hold on it. The database has the final say through the overlap
+// constraint, so a concurrent booking that slips in between picking the
+// copy and inserting still comes back as a conflict.
+func (s *sqlStore) Reserve(ctx context.Context, userID, bookID int, start, end time.Time, maxItemsOut int) (int, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if maxItemsOut > 0 {
+		// locking the user makes concurrent reservations of the same user
+		// wait, so they cannot both pass the count
+		var locked int
+		if err := tx.GetContext(ctx, &locked, `SELECT id FROM users WHERE id = $1 FOR UPDATE`, userID); err != nil {
+			if notFound(err) == ErrNotFound {
+				return 0, ErrInvalidReference
+			}
+			return 0, err
+		}
+		out := 0
+		if err := tx.GetContext(ctx, &out, `SELECT count(*) FROM bookings WHERE user_id = $1 AND status IN `+activeBookingStatuses, userID); err != nil {
+			return 0, err
+		}
+		if out >= maxItemsOut {
+			return 0, ErrItemsOutLimit
+		}
+	}
+
+	var copyID int
+	err = tx.GetContext(ctx, &copyID, `SELECT c.id FROM book_copies c WHERE c.book_id = $1 AND c.status = true
+		AND NOT EXISTS (`+overlappingBooking+`) AND NOT EXISTS (`+heldForOther+`)
+		ORDER BY EXISTS (SELECT 1 FROM holds h WHERE h.copy_id = c.id AND h.status = 'ready' AND h.user_id = $4) DESC, c.id
+		LIMIT 1`, bookID, start, end, userID)
+	if err != nil {
+		if notFound(err) == ErrNotFound {
+			return 0, ErrConflict
+		}
+		return 0, err
+	}
+	const insertBooking = `INSERT INTO bookings(user_id, book_id, copy_id, start_time, end_time, status) VALUES($1, $2, $3, $4, $5, $6) RETURNING id`
+	var id int
+	if err := tx.GetContext(ctx, &id, insertBooking, userID, bookID, copyID, start, end, BookingReserved); err != nil {
+		if isExclusionViolation(err) {
+			return 0, ErrConflict
+		}
+		if isForeignKeyViolation(err) {
+			return 0, ErrInvalidReference
+		}
+		return 0, err
+	}
+	const fulfil = `UPDATE holds SET status = $3, closed_at = localtimestamp WHERE user_id = $1 AND book_id = $2 AND status IN ($4, $5)`
+	if _, err := tx.ExecContext(ctx, fulfil, userID, bookID, HoldFulfilled, HoldWaiting, HoldReady); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+func (s *sqlStore) NextFreeSlot(ctx context.Context, bookID int, from time.Time, length time.Duration) (time.Time, bool, error) {
+	copies := []int{}
+	if err := s.db.SelectContext(ctx, &copies, `SELECT id FROM book_copies WHERE book_id = $1 AND status = true`, bookID); err != nil {
+		return time.Time{}, false, err
+	}
+
+	var best time.Time
+	found := false
+	for _, copyID := range copies {
+		booked := []Booking{}
+		err := s.db.SelectContext(ctx, &booked, `SELECT start_time, end_time FROM bookings
+			WHERE copy_id = $1 AND status IN `+activeBookingStatuses+` AND end_time > $2 ORDER BY start_time`, copyID, from)
+		if err != nil {
+			return time.Time{}, false, err
+		}
+		t := from
+		for _, b := range booked {
+			if !b.StartTime.Before(t.Add(length)) {
+				break
+			}
+			if b.EndTime.After(t) {
+				t = b.EndTime
+			}
+		}
+		if !found || t.Before(best) {
+			best = t
+			found = true
+		}
+	}
+	return best, found, nil
+}
+
+func (s *sqlStore) Schedule(ctx context.Context, bookID int, from, to time.Time) ([]Booking, error) {
+	bookings := []Booking{}
+	err := s.db.SelectContext(ctx, &bookings, selectBooking+` WHERE bk.book_id = $1 AND bk.status IN `+activeBookingStatuses+`
+		AND bk.start_time < $3 AND (bk.end_time > $2 OR bk.status = $4) ORDER BY bk.copy_id, bk.start_time`,
+		bookID, from, to, BookingCheckedOut)
+	return bookings, err
+}
+
+func (s *sqlStore) Booking(ctx context.Context, id int) (Booking, error) {
+	var booking Booking
+	err := s.db.GetContext(ctx, &booking, selectBooking+` WHERE bk.id = $1`, id)
+	return booking, notFound(err)
+}
+
+func (s *sqlStore) ListBookings(ctx context.Context, filter BookingFilter, offset, limit int) ([]Booking, int, error) {
+	where, args := bookingWhere(filter)
+	total := 0
+	if err := s.db.GetContext(ctx, &total, `SELECT count(*) FROM bookings bk`+where, args...); err != nil {
+		return nil, 0, err
+	}
+	bookings := []Booking{}
+	args = append(args, limit, offset)
+	query := fmt.Sprintf("%s%s ORDER BY bk.start_time DESC, bk.id DESC LIMIT $%d OFFSET $%d", selectBooking, where, len(args)-1, len(args))
+	err := s.db.SelectContext(ctx, &bookings, query, args...)
+	return bookings, total, err
+}
+
+func (s *sqlStore) BookingsAfter(ctx context.Context, filter BookingFilter, after *BookingCursor, limit int) ([]Booking, error) {
+	where, args := bookingWhere(filter)
+	if after != nil {
+		args = append(args, after.StartTime, after.ID)
+		cond := fmt.Sprintf("(bk.start_time < $%d OR (bk.start_time = $%d AND bk.id < $%d))", len(args)-1, len(args)-1, len(args))
+		if where == "" {
+			where = " WHERE " + cond
+		} else {
+			where += " AND " + cond
+		}
+	}
+	bookings := []Booking{}
+	args = append(args, limit)
+	query := fmt.Sprintf("%s%s ORDER BY bk.start_time DESC, bk.id DESC LIMIT $%d", selectBooking, where, len(args))
+	err := s.db.SelectContext(ctx, &bookings, query, args...)
+	return bookings, err
+}
+
+// bookingWhere builds the SQL filter for the bookings table aliased as bk.
+func bookingWhere(f BookingFilter) (string, []interface{}) {
+	conds := []string{}
+	args := []interface{}{}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+	if f.UserID != 0 {
+		add("bk.user_id = $%d", f.UserID)
+	}
+	if f.User != "" {
+		add("bk.user_id IN (SELECT id FROM users WHERE email ILIKE '%%' || $%d || '%%')", f.User)
+	}
+	if f.BookID != 0 {
+		add("bk.book_id = $%d", f.BookID)
+	}
+	if from, err := time.Parse("2006-01-02", f.From); err == nil {
+		add("bk.end_time > $%d", from)
+	}
+	if to, err := time.Parse("2006-01-02", f.To); err == nil {
+		add("bk.start_time < $%d", to.AddDate(0, 0, 1))
+	}
+	if f.Status != "" {
+		add("bk.status = $%d", f.Status)
+	}
+	if len(conds) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// Transition stamps the timestamp that matches the new status and records
+// the change in booking_events.
+func (s *sqlStore) Transition(ctx context.Context, id int, to string, performedBy int) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var from string
+	if err := tx.GetContext(ctx, &from, `SELECT status FROM bookings WHERE id = $1 FOR UPDATE`, id); err != nil {
+		return notFound(err)
+	}
+	if !CanTransition(from, to) {
+		return ErrInvalidTransition
+	}
+
+	const updateStatus = `UPDATE bookings SET status = $2,
+		checked_out_at = CASE WHEN $2 = 'checked_out' THEN localtimestamp ELSE checked_out_at END,
+		returned_at = CASE WHEN $2 = 'returned' THEN localtimestamp ELSE returned_at END,
+		cancelled_at = CASE WHEN $2 = 'cancelled' THEN localtimestamp ELSE cancelled_at END
+		WHERE id = $1`
+	if _, err := tx.ExecContext(ctx, updateStatus, id, to); err != nil {
+		return err
+	}
+	const insertEvent = `INSERT INTO booking_events(booking_id, from_status, to_status, performed_by, created_at) VALUES($1, $2, $3, $4, localtimestamp)`
+	if _, err := tx.ExecContext(ctx, insertEvent, id, from, to, performedBy); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ExpireReservations moves reservations whose window has ended to no_show
+// and records each change without a performer.
+func (s *sqlStore) ExpireReservations(ctx context.Context) (int, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	ids := []int{}
+	const expired = `SELECT id FROM bookings WHERE status = $1 AND end_time <= localtimestamp FOR UPDATE`
+	if err := tx.SelectContext(ctx, &ids, expired, BookingReserved); err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		if _, err := tx.ExecContext(ctx, `UPDATE bookings SET status = $2 WHERE id = $1`, id, BookingNoShow); err != nil {
+			return 0, err
+		}
+		const insertEvent = `INSERT INTO booking_events(booking_id, from_status, to_status, created_at) VALUES($1, $2, $3, localtimestamp)`
+		if _, err := tx.ExecContext(ctx, insertEvent, id, BookingReserved, BookingNoShow); err != nil {
+			return 0, err
+		}
+	}
+	return len(ids), tx.Commit()
+}
+
+func (s *sqlStore) Renew(ctx context.Context, id int, period time.Duration, maxRenewals, performedBy int) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var booking struct {
+		BookID   int       `db:"book_id"`
+		EndTime  time.Time `db:"end_time"`
+		Status   string    `db:"status"`
+		Overdue  bool      `db:"overdue"`
+		Renewals int       `db:"renewals"`
+	}
+	const current = `SELECT book_id, end_time, status, end_time <= localtimestamp AS overdue,
+		(SELECT count(*) FROM booking_renewals r WHERE r.booking_id = bookings.id) AS renewals
+		FROM bookings WHERE id = $1 FOR UPDATE`
+	if err := tx.GetContext(ctx, &booking, current, id); err != nil {
+		return notFound(err)
+	}
+	if !(Booking{Status: booking.Status}).CanRenew() || booking.Overdue {
+		return ErrNotRenewable
+	}
+	if booking.Renewals >= maxRenewals {
+		return ErrRenewalLimit
+	}
+	// ready holds count as well, as their members have not collected the
+	// book yet
+	var held bool
+	const openHolds = `SELECT EXISTS (SELECT 1 FROM holds WHERE book_id = $1 AND status IN ($2, $3))`
+	if err := tx.GetContext(ctx, &held, openHolds, booking.BookID, HoldWaiting, HoldReady); err != nil {
+		return err
+	}
+	if held {
+		return ErrHoldsWaiting
+	}
+
+	newEnd := booking.EndTime.Add(period)
+	if _, err := tx.ExecContext(ctx, `UPDATE bookings SET end_time = $2 WHERE id = $1`, id, newEnd); err != nil {
+		if isExclusionViolation(err) {
+			return ErrConflict
+		}
+		return err
+	}
+	const insertRenewal = `INSERT INTO booking_renewals(booking_id, previous_end_time, new_end_time, renewed_by, created_at)
+		VALUES($1, $2, $3, $4, localtimestamp)`
+	if _, err := tx.ExecContext(ctx, insertRenewal, id, booking.EndTime, newEnd, performedBy); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
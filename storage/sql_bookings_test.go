@@ -0,0 +1,209 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReserveOverlap(t *testing.T) {
+	l := newTestLibrary(t)
+	start, end := window(3, 2*day)
+
+	first, err := l.Bookings.Reserve(l.ctx, l.max.ID, l.dune.ID, start, end, 0)
+	l.must(err)
+	// Dune has a single copy, so any window that overlaps is refused, while
+	// one that starts as the first ends is not
+	if _, err := l.Bookings.Reserve(l.ctx, l.ann.ID, l.dune.ID, start.Add(day), end.Add(day), 0); err != ErrConflict {
+		t.Errorf("overlapping booking: got %v, want ErrConflict", err)
+	}
+	if _, err := l.Bookings.Reserve(l.ctx, l.ann.ID, l.dune.ID, end, end.Add(day), 0); err != nil {
+		t.Errorf("booking right after: %v", err)
+	}
+	if next, ok, err := l.Bookings.NextFreeSlot(l.ctx, l.dune.ID, start, day); err != nil || !ok || !next.Equal(end.Add(day)) {
+		t.Errorf("next free slot: got %v %v %v, want %v", next, ok, err, end.Add(day))
+	}
+
+	// a cancelled booking frees its copy
+	l.must(l.Bookings.Transition(l.ctx, first, BookingCancelled, l.ann.ID))
+	if _, err := l.Bookings.Reserve(l.ctx, l.ann.ID, l.dune.ID, start, end, 0); err != nil {
+		t.Errorf("booking the cancelled window: %v", err)
+	}
+}
+
+func TestReserveUsesEveryCopy(t *testing.T) {
+	l := newTestLibrary(t)
+	start, end := window(1, day)
+
+	copies := map[int]bool{}
+	for _, userID := range []int{l.ann.ID, l.max.ID} {
+		id, err := l.Bookings.Reserve(l.ctx, userID, l.spqr.ID, start, end, 0)
+		l.must(err)
+		booking, err := l.Bookings.Booking(l.ctx, id)
+		l.must(err)
+		copies[booking.CopyID] = true
+	}
+	if len(copies) != 2 {
+		t.Errorf("got copies %v, want both copies of SPQR booked", copies)
+	}
+	if _, err := l.Bookings.Reserve(l.ctx, l.max.ID, l.spqr.ID, start, end, 0); err != ErrConflict {
+		t.Errorf("third booking: got %v, want ErrConflict", err)
+	}
+}
+
+func TestReserveItemsOutLimit(t *testing.T) {
+	l := newTestLibrary(t)
+	start, end := window(1, day)
+
+	_, err := l.Bookings.Reserve(l.ctx, l.max.ID, l.dune.ID, start, end, 1)
+	l.must(err)
+	if _, err := l.Bookings.Reserve(l.ctx, l.max.ID, l.spqr.ID, start, end, 1); err != ErrItemsOutLimit {
+		t.Errorf("second item: got %v, want ErrItemsOutLimit", err)
+	}
+	if _, err := l.Bookings.Reserve(l.ctx, l.max.ID, l.spqr.ID, start, end, 2); err != nil {
+		t.Errorf("second item under a higher limit: %v", err)
+	}
+	if _, err := l.Bookings.Reserve(l.ctx, 99, l.spqr.ID, start, end, 1); err != ErrInvalidReference {
+		t.Errorf("unknown user: got %v, want ErrInvalidReference", err)
+	}
+}
+
+func TestTransition(t *testing.T) {
+	l := newTestLibrary(t)
+	start, end := window(0, day)
+	id, err := l.Bookings.Reserve(l.ctx, l.max.ID, l.dune.ID, start, end, 0)
+	l.must(err)
+
+	if err := l.Bookings.Transition(l.ctx, id, BookingReturned, l.ann.ID); err != ErrInvalidTransition {
+		t.Errorf("returning a reservation: got %v, want ErrInvalidTransition", err)
+	}
+	l.must(l.Bookings.Transition(l.ctx, id, BookingCheckedOut, l.ann.ID))
+	l.must(l.Bookings.Transition(l.ctx, id, BookingReturned, l.ann.ID))
+	booking, err := l.Bookings.Booking(l.ctx, id)
+	l.must(err)
+	if booking.Status != BookingReturned || booking.CheckedOutAt == nil || booking.ReturnedAt == nil || booking.CancelledAt != nil {
+		t.Errorf("got booking %+v, want it returned with check-out and return times", booking)
+	}
+	if err := l.Bookings.Transition(l.ctx, id, BookingCancelled, l.ann.ID); err != ErrInvalidTransition {
+		t.Errorf("cancelling a returned booking: got %v, want ErrInvalidTransition", err)
+	}
+	if err := l.Bookings.Transition(l.ctx, 99, BookingCancelled, l.ann.ID); err != ErrNotFound {
+		t.Errorf("missing booking: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestExpireReservations(t *testing.T) {
+	l := newTestLibrary(t)
+	start, end := window(-2, day)
+	missed, err := l.Bookings.Reserve(l.ctx, l.max.ID, l.dune.ID, start, end, 0)
+	l.must(err)
+	collected, err := l.Bookings.Reserve(l.ctx, l.max.ID, l.spqr.ID, start, end, 0)
+	l.must(err)
+	l.must(l.Bookings.Transition(l.ctx, collected, BookingCheckedOut, l.ann.ID))
+	upcoming, err := l.Bookings.Reserve(l.ctx, l.ann.ID, l.dune.ID, start.AddDate(0, 0, 3), end.AddDate(0, 0, 3), 0)
+	l.must(err)
+
+	if n, err := l.Bookings.ExpireReservations(l.ctx); err != nil || n != 1 {
+		t.Fatalf("got %d expired, %v, want 1", n, err)
+	}
+	for id, want := range map[int]string{missed: BookingNoShow, collected: BookingCheckedOut, upcoming: BookingReserved} {
+		if booking, _ := l.Bookings.Booking(l.ctx, id); booking.Status != want {
+			t.Errorf("booking %d: got %s, want %s", id, booking.Status, want)
+		}
+	}
+}
+
+func TestRenew(t *testing.T) {
+	l := newTestLibrary(t)
+	start, end := window(0, day)
+	id, err := l.Bookings.Reserve(l.ctx, l.max.ID, l.dune.ID, start, end, 0)
+	l.must(err)
+	l.must(l.Bookings.Transition(l.ctx, id, BookingCheckedOut, l.ann.ID))
+
+	l.must(l.Bookings.Renew(l.ctx, id, 2*day, 1, l.max.ID))
+	booking, err := l.Bookings.Booking(l.ctx, id)
+	l.must(err)
+	if !booking.EndTime.Equal(end.Add(2*day)) || booking.Renewals != 1 {
+		t.Errorf("got end %v after %d renewals, want %v after 1", booking.EndTime, booking.Renewals, end.Add(2*day))
+	}
+	if err := l.Bookings.Renew(l.ctx, id, 2*day, 1, l.max.ID); err != ErrRenewalLimit {
+		t.Errorf("renewal over the limit: got %v, want ErrRenewalLimit", err)
+	}
+
+	// the copy is booked after the loan, so it cannot be moved into that
+	_, err = l.Bookings.Reserve(l.ctx, l.ann.ID, l.dune.ID, booking.EndTime.Add(time.Hour), booking.EndTime.Add(day), 0)
+	l.must(err)
+	if err := l.Bookings.Renew(l.ctx, id, 2*day, 5, l.max.ID); err != ErrConflict {
+		t.Errorf("renewal into the next booking: got %v, want ErrConflict", err)
+	}
+
+	overdueStart, overdueEnd := window(-3, day)
+	overdue, err := l.Bookings.Reserve(l.ctx, l.max.ID, l.spqr.ID, overdueStart, overdueEnd, 0)
+	l.must(err)
+	l.must(l.Bookings.Transition(l.ctx, overdue, BookingCheckedOut, l.ann.ID))
+	if err := l.Bookings.Renew(l.ctx, overdue, day, 5, l.max.ID); err != ErrNotRenewable {
+		t.Errorf("renewing an overdue loan: got %v, want ErrNotRenewable", err)
+	}
+}
+
+func TestRenewWithHolds(t *testing.T) {
+	l := newTestLibrary(t)
+	start, end := window(0, day)
+	id, err := l.Bookings.Reserve(l.ctx, l.max.ID, l.dune.ID, start, end, 0)
+	l.must(err)
+	l.must(l.Bookings.Transition(l.ctx, id, BookingCheckedOut, l.ann.ID))
+
+	_, err = l.Holds.JoinWaitlist(l.ctx, l.ann.ID, l.dune.ID)
+	l.must(err)
+	if err := l.Bookings.Renew(l.ctx, id, day, 5, l.max.ID); err != ErrHoldsWaiting {
+		t.Errorf("renewal with a waiting hold: got %v, want ErrHoldsWaiting", err)
+	}
+
+	// SPQR has a second copy, which is kept for Ann once her hold is ready
+	loan, err := l.Bookings.Reserve(l.ctx, l.max.ID, l.spqr.ID, start, end, 0)
+	l.must(err)
+	l.must(l.Bookings.Transition(l.ctx, loan, BookingCheckedOut, l.ann.ID))
+	hold, err := l.Holds.JoinWaitlist(l.ctx, l.ann.ID, l.spqr.ID)
+	l.must(err)
+	if promoted, err := l.Holds.PromoteHolds(l.ctx, day); err != nil || len(promoted) != 1 || promoted[0].ID != hold {
+		t.Fatalf("got promoted %+v, %v, want Ann's hold", promoted, err)
+	}
+	if err := l.Bookings.Renew(l.ctx, loan, day, 5, l.max.ID); err != ErrHoldsWaiting {
+		t.Errorf("renewal with a ready hold: got %v, want ErrHoldsWaiting", err)
+	}
+	l.must(l.Holds.CancelHold(l.ctx, hold, l.ann.ID))
+	if err := l.Bookings.Renew(l.ctx, loan, day, 5, l.max.ID); err != nil {
+		t.Errorf("renewal once the hold is cancelled: %v", err)
+	}
+}
+
+func TestBookingsAfter(t *testing.T) {
+	l := newTestLibrary(t)
+	ids := []int{}
+	for i := 0; i < 3; i++ {
+		start, end := window(i*2, day)
+		id, err := l.Bookings.Reserve(l.ctx, l.max.ID, l.dune.ID, start, end, 0)
+		l.must(err)
+		ids = append(ids, id)
+	}
+	_, err := l.Bookings.Reserve(l.ctx, l.ann.ID, l.spqr.ID, WallClock(), WallClock().Add(day), 0)
+	l.must(err)
+
+	filter := BookingFilter{UserID: l.max.ID}
+	page, err := l.Bookings.BookingsAfter(l.ctx, filter, nil, 2)
+	l.must(err)
+	if len(page) != 2 || page[0].ID != ids[2] || page[1].ID != ids[1] {
+		t.Fatalf("first page: got %+v, want the two latest of Max's bookings", page)
+	}
+	last := page[len(page)-1]
+	page, err = l.Bookings.BookingsAfter(l.ctx, filter, &BookingCursor{StartTime: last.StartTime, ID: last.ID}, 2)
+	l.must(err)
+	if len(page) != 1 || page[0].ID != ids[0] {
+		t.Errorf("second page: got %+v, want Max's first booking", page)
+	}
+
+	listed, total, err := l.Bookings.ListBookings(l.ctx, BookingFilter{User: "MAX@"}, 0, 10)
+	l.must(err)
+	if total != 3 || len(listed) != 3 {
+		t.Errorf("filtering by email: got %d of %d, want Max's 3 bookings", len(listed), total)
+	}
+}
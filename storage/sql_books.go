@@ -0,0 +1,211 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// bookColumns adds the category name and copy counts to the columns of a
+// book. The columns are listed because Postgres also keeps the search
+// document in the table.
+const bookColumns = `b.id, b.category_id, b.book_name, b.author_name, b.details, b.image, b.status,
+	COALESCE(cat.name, '') AS cat_name,
+	(SELECT count(*) FROM book_copies c WHERE c.book_id = b.id) AS total_copies,
+	(SELECT count(*) FROM book_copies c WHERE c.book_id = b.id AND ` + availableCopyFilter + `) AS available_copies`
+
+const bookFrom = `FROM books b LEFT JOIN categories cat ON cat.id = b.category_id`
+
+const selectBook = `SELECT ` + bookColumns + ` ` + bookFrom
+
+const selectCopy = `SELECT c.*, (` + availableCopyFilter + `) AS available FROM book_copies c`
+
+func (s *sqlStore) Books(ctx context.Context) ([]Book, error) {
+	books := []Book{}
+	err := s.db.SelectContext(ctx, &books, selectBook+` ORDER BY b.book_name`)
+	return books, err
+}
+
+func (s *sqlStore) PageBooks(ctx context.Context, offset, limit int) ([]Book, int, error) {
+	total := 0
+	if err := s.db.GetContext(ctx, &total, `SELECT count(*) FROM books`); err != nil {
+		return nil, 0, err
+	}
+	books := []Book{}
+	err := s.db.SelectContext(ctx, &books, selectBook+` ORDER BY b.id LIMIT $1 OFFSET $2`, limit, offset)
+	return books, total, err
+}
+
+// bookSorts maps BookSearch.Sort to the column it sorts by.
+var bookSorts = map[string]string{
+	"name":      "b.book_name",
+	"author":    "b.author_name",
+	"category":  "cat_name",
+	"added":     "b.id",
+	"available": "available_copies",
+}
+
+func (s *sqlStore) SearchBooks(ctx context.Context, search BookSearch, offset, limit int) ([]Book, int, error) {
+	ts := s.db.d.search
+	columns, from, order := bookColumns, bookFrom, ""
+	conds := []string{}
+	args := []interface{}{}
+	sort := search.Sort
+	if query := ts.prepare(search.Query); query != "" {
+		args = append(args, query)
+		columns += ", " + ts.snippet + " AS snippet"
+		from += " " + ts.join
+		conds = append(conds, ts.match)
+		order = ts.order + ", b.book_name"
+	} else if _, ok := bookSorts[sort]; !ok {
+		sort = "name"
+	}
+	if search.CategoryID != 0 {
+		args = append(args, search.CategoryID)
+		conds = append(conds, fmt.Sprintf("b.category_id = $%d", len(args)))
+	}
+	if search.Author != "" {
+		args = append(args, search.Author)
+		conds = append(conds, fmt.Sprintf("b.author_name ILIKE '%%' || $%d || '%%'", len(args)))
+	}
+	if search.Available {
+		conds = append(conds, `b.status = true AND EXISTS (SELECT 1 FROM book_copies c WHERE c.book_id = b.id AND `+availableCopyFilter+`)`)
+	}
+	if column, ok := bookSorts[sort]; ok {
+		order = column
+		if search.Desc {
+			order += " DESC"
+		}
+	}
+	// the ID keeps pages stable when the sort column has duplicates
+	order += ", b.id"
+	where := ""
+	if len(conds) > 0 {
+		where = " WHERE " + strings.Join(conds, " AND ")
+	}
+
+	total := 0
+	if err := s.db.GetContext(ctx, &total, `SELECT count(*) `+from+where, args...); err != nil {
+		return nil, 0, err
+	}
+	books := []Book{}
+	args = append(args, limit, offset)
+	query := fmt.Sprintf("SELECT %s %s%s ORDER BY %s LIMIT $%d OFFSET $%d", columns, from, where, order, len(args)-1, len(args))
+	if err := s.db.SelectContext(ctx, &books, query, args...); err != nil {
+		return nil, 0, err
+	}
+	for i := range books {
+		books[i].Snippet = highlight(books[i].Snippet)
+	}
+	return books, total, nil
+}
+
+func (s *sqlStore) Book(ctx context.Context, id int) (Book, error) {
+	var book Book
+	err := s.db.GetContext(ctx, &book, selectBook+` WHERE b.id = $1`, id)
+	return book, notFound(err)
+}
+
+func (s *sqlStore) CreateBook(ctx context.Context, book *Book) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	const insertBook = `INSERT INTO books(category_id, book_name, author_name, details, image, status) VALUES($1, $2, $3, $4, $5, $6) RETURNING id`
+	if err := tx.GetContext(ctx, &book.ID, insertBook, book.Category_id, book.Book_name, book.AuthorName, book.Details, book.Image, book.Status); err != nil {
+		if isForeignKeyViolation(err) {
+			return ErrInvalidReference
+		}
+		return err
+	}
+	if book.Copies < 1 {
+		book.Copies = 1
+	}
+	const insertCopy = `INSERT INTO book_copies(book_id, barcode, condition, shelf_location, status) VALUES($1, $2, $3, $4, $5)`
+	for i := 1; i <= book.Copies; i++ {
+		if _, err := tx.ExecContext(ctx, insertCopy, book.ID, fmt.Sprintf("BK%05d-%02d", book.ID, i), "new", "Unshelved", true); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) UpdateBook(ctx context.Context, book Book) error {
+	const updateBook = `UPDATE books SET category_id = $2, book_name = $3, author_name = $4, details = $5, image = $6, status = $7 WHERE id = $1`
+	err := rowsAffected(s.db.ExecContext(ctx, updateBook, book.ID, book.Category_id, book.Book_name, book.AuthorName, book.Details, book.Image, book.Status))
+	if isForeignKeyViolation(err) {
+		return ErrInvalidReference
+	}
+	return err
+}
+
+func (s *sqlStore) DeleteBook(ctx context.Context, id int) error {
+	return s.deleteUnlessBooked(ctx, "books", "book_id", id)
+}
+
+func (s *sqlStore) Copies(ctx context.Context, bookID int) ([]BookCopy, error) {
+	copies := []BookCopy{}
+	err := s.db.SelectContext(ctx, &copies, selectCopy+` WHERE c.book_id = $1 ORDER BY c.id`, bookID)
+	return copies, err
+}
+
+func (s *sqlStore) Copy(ctx context.Context, id int) (BookCopy, error) {
+	var bookCopy BookCopy
+	err := s.db.GetContext(ctx, &bookCopy, selectCopy+` WHERE c.id = $1`, id)
+	return bookCopy, notFound(err)
+}
+
+func (s *sqlStore) CreateCopy(ctx context.Context, bookCopy *BookCopy) error {
+	const insertCopy = `INSERT INTO book_copies(book_id, barcode, condition, shelf_location, status) VALUES($1, $2, $3, $4, $5) RETURNING id`
+	err := s.db.GetContext(ctx, &bookCopy.ID, insertCopy, bookCopy.BookID, bookCopy.Barcode, bookCopy.Condition, bookCopy.ShelfLocation, bookCopy.Status)
+	if isForeignKeyViolation(err) {
+		return ErrInvalidReference
+	}
+	return err
+}
+
+func (s *sqlStore) UpdateCopy(ctx context.Context, bookCopy BookCopy) error {
+	const updateCopy = `UPDATE book_copies SET barcode = $2, condition = $3, shelf_location = $4, status = $5 WHERE id = $1`
+	return rowsAffected(s.db.ExecContext(ctx, updateCopy, bookCopy.ID, bookCopy.Barcode, bookCopy.Condition, bookCopy.ShelfLocation, bookCopy.Status))
+}
+
+func (s *sqlStore) DeleteCopy(ctx context.Context, id int) error {
+	return s.deleteUnlessBooked(ctx, "book_copies", "copy_id", id)
+}
+
+func (s *sqlStore) BarcodeTaken(ctx context.Context, barcode string, exceptID int) (bool, error) {
+	n := 0
+	err := s.db.GetContext(ctx, &n, `SELECT count(*) FROM book_copies WHERE barcode = $1 AND id <> $2`, barcode, exceptID)
+	return n > 0, err
+}
+
+// deleteUnlessBooked deletes the row id of table, which is books or
+// book_copies, unless a booking referencing it through column is active.
+// Finished bookings are removed with it by the foreign keys. The row lock
+// makes concurrent reservations, whose foreign key check needs a share lock
+// on the same row, wait until the delete is done.
+func (s *sqlStore) deleteUnlessBooked(ctx context.Context, table, column string, id int) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var locked int
+	if err := tx.GetContext(ctx, &locked, `SELECT id FROM `+table+` WHERE id = $1 FOR UPDATE`, id); err != nil {
+		return notFound(err)
+	}
+	var active int
+	if err := tx.GetContext(ctx, &active, `SELECT count(*) FROM bookings WHERE `+column+` = $1 AND status IN `+activeBookingStatuses, id); err != nil {
+		return err
+	}
+	if active > 0 {
+		return ErrActiveBookings
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE id = $1`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
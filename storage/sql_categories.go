@@ -0,0 +1,72 @@
+package storage
+
+import "context"
+
+func (s *sqlStore) Categories(ctx context.Context) ([]Category, error) {
+	categories := []Category{}
+	err := s.db.SelectContext(ctx, &categories, `SELECT * FROM categories ORDER BY name`)
+	return categories, err
+}
+
+func (s *sqlStore) PageCategories(ctx context.Context, offset, limit int) ([]Category, int, error) {
+	total := 0
+	if err := s.db.GetContext(ctx, &total, `SELECT count(*) FROM categories`); err != nil {
+		return nil, 0, err
+	}
+	categories := []Category{}
+	err := s.db.SelectContext(ctx, &categories, `SELECT * FROM categories ORDER BY id LIMIT $1 OFFSET $2`, limit, offset)
+	return categories, total, err
+}
+
+func (s *sqlStore) SearchCategories(ctx context.Context, query string) ([]Category, error) {
+	categories := []Category{}
+	err := s.db.SelectContext(ctx, &categories, `SELECT * FROM categories WHERE name ILIKE '%' || $1 || '%' ORDER BY name`, query)
+	return categories, err
+}
+
+func (s *sqlStore) Category(ctx context.Context, id int) (Category, error) {
+	var category Category
+	err := s.db.GetContext(ctx, &category, `SELECT * FROM categories WHERE id = $1`, id)
+	return category, notFound(err)
+}
+
+func (s *sqlStore) CreateCategory(ctx context.Context, category *Category) error {
+	const insertCategory = `INSERT INTO categories(name, status) VALUES($1, $2) RETURNING id`
+	return s.db.GetContext(ctx, &category.ID, insertCategory, category.Name, category.Status)
+}
+
+func (s *sqlStore) UpdateCategory(ctx context.Context, category Category) error {
+	const updateCategory = `UPDATE categories SET name = $2, status = $3 WHERE id = $1`
+	return rowsAffected(s.db.ExecContext(ctx, updateCategory, category.ID, category.Name, category.Status))
+}
+
+func (s *sqlStore) CountBooks(ctx context.Context, categoryID int) (int, error) {
+	n := 0
+	err := s.db.GetContext(ctx, &n, `SELECT count(*) FROM books WHERE category_id = $1`, categoryID)
+	return n, err
+}
+
+// DeleteCategory relies on the books_category_id_fkey constraint, which
+// refuses the delete if a book is added to the category concurrently.
+func (s *sqlStore) DeleteCategory(ctx context.Context, id, reassignTo int) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if reassignTo != 0 {
+		if _, err := tx.ExecContext(ctx, `UPDATE books SET category_id = $2 WHERE category_id = $1`, id, reassignTo); err != nil {
+			if isForeignKeyViolation(err) {
+				return ErrInvalidReference
+			}
+			return err
+		}
+	}
+	if err := rowsAffected(tx.ExecContext(ctx, `DELETE FROM categories WHERE id = $1`, id)); err != nil {
+		if isForeignKeyViolation(err) {
+			return ErrInUse
+		}
+		return err
+	}
+	return tx.Commit()
+}
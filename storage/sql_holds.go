@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// selectHold adds the book name, the member's email and first name, and the
+// place in the queue to each holds row.
+const selectHold = `SELECT h.*, COALESCE(b.book_name, '') AS book_name,
+	COALESCE(u.email, '') AS user_email, COALESCE(u.first_name, '') AS user_name,
+	(SELECT count(*) FROM holds w WHERE w.book_id = h.book_id AND w.status = 'waiting' AND w.id <= h.id) AS position
+	FROM holds h
+	LEFT JOIN books b ON b.id = h.book_id
+	LEFT JOIN users u ON u.id = h.user_id`
+
+func (s *sqlStore) JoinWaitlist(ctx context.Context, userID, bookID int) (int, error) {
+	const insertHold = `INSERT INTO holds(user_id, book_id, status, created_at) VALUES($1, $2, $3, localtimestamp) RETURNING id`
+	var id int
+	if err := s.db.GetContext(ctx, &id, insertHold, userID, bookID, HoldWaiting); err != nil {
+		if isUniqueViolation(err) {
+			return 0, ErrAlreadyWaiting
+		}
+		if isForeignKeyViolation(err) {
+			return 0, ErrInvalidReference
+		}
+		return 0, err
+	}
+	return id, nil
+}
+
+func (s *sqlStore) Hold(ctx context.Context, id int) (Hold, error) {
+	var hold Hold
+	err := s.db.GetContext(ctx, &hold, selectHold+` WHERE h.id = $1`, id)
+	return hold, notFound(err)
+}
+
+func (s *sqlStore) UserHolds(ctx context.Context, userID int) ([]Hold, error) {
+	holds := []Hold{}
+	err := s.db.SelectContext(ctx, &holds, selectHold+` WHERE h.user_id = $1 AND h.status IN ($2, $3) ORDER BY h.id`,
+		userID, HoldWaiting, HoldReady)
+	return holds, err
+}
+
+func (s *sqlStore) CancelHold(ctx context.Context, id, userID int) error {
+	const cancel = `UPDATE holds SET status = $3, closed_at = localtimestamp WHERE id = $1 AND user_id = $2 AND status IN ($4, $5)`
+	return rowsAffected(s.db.ExecContext(ctx, cancel, id, userID, HoldCancelled, HoldWaiting, HoldReady))
+}
+
+// PromoteHolds runs in one transaction. Locking the waiting holds of a title
+// keeps two runs from handing the same copy to different members.
+func (s *sqlStore) PromoteHolds(ctx context.Context, period time.Duration) ([]Hold, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// expires_at is written and compared on the app's wall clock, which can
+	// be in another zone than the database's localtimestamp
+	now := WallClock()
+	const expire = `UPDATE holds SET status = $1, closed_at = localtimestamp WHERE status = $2 AND expires_at <= $3`
+	if _, err := tx.ExecContext(ctx, expire, HoldExpired, HoldReady, now); err != nil {
+		return nil, err
+	}
+	// A ready hold whose copy was deleted goes back to waiting. It keeps
+	// its ID, and so its place at the front of the queue.
+	const requeue = `UPDATE holds SET status = $1, ready_at = NULL, expires_at = NULL WHERE status = $2 AND copy_id IS NULL`
+	if _, err := tx.ExecContext(ctx, requeue, HoldWaiting, HoldReady); err != nil {
+		return nil, err
+	}
+
+	books := []int{}
+	if err := tx.SelectContext(ctx, &books, `SELECT DISTINCT book_id FROM holds WHERE status = $1`, HoldWaiting); err != nil {
+		return nil, err
+	}
+	expiresAt := now.Add(period)
+	promoted := []int{}
+	for _, bookID := range books {
+		waiting := []int{}
+		const queue = `SELECT id FROM holds WHERE book_id = $1 AND status = $2 ORDER BY id FOR UPDATE`
+		if err := tx.SelectContext(ctx, &waiting, queue, bookID, HoldWaiting); err != nil {
+			return nil, err
+		}
+		free := []int{}
+		const freeCopies = `SELECT c.id FROM book_copies c WHERE c.book_id = $1 AND ` + availableCopyFilter + ` ORDER BY c.id`
+		if err := tx.SelectContext(ctx, &free, freeCopies, bookID); err != nil {
+			return nil, err
+		}
+		for i := 0; i < len(waiting) && i < len(free); i++ {
+			const ready = `UPDATE holds SET status = $2, copy_id = $3, ready_at = localtimestamp, expires_at = $4 WHERE id = $1`
+			if _, err := tx.ExecContext(ctx, ready, waiting[i], HoldReady, free[i], expiresAt); err != nil {
+				return nil, err
+			}
+			promoted = append(promoted, waiting[i])
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	holds := make([]Hold, 0, len(promoted))
+	for _, id := range promoted {
+		hold, err := s.Hold(ctx, id)
+		if err != nil {
+			return holds, err
+		}
+		holds = append(holds, hold)
+	}
+	return holds, nil
+}
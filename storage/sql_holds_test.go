@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWaitlist(t *testing.T) {
+	l := newTestLibrary(t)
+	start, end := window(0, day)
+	loan, err := l.Bookings.Reserve(l.ctx, l.ann.ID, l.dune.ID, start, end, 0)
+	l.must(err)
+	l.must(l.Bookings.Transition(l.ctx, loan, BookingCheckedOut, l.ann.ID))
+
+	id, err := l.Holds.JoinWaitlist(l.ctx, l.max.ID, l.dune.ID)
+	l.must(err)
+	if _, err := l.Holds.JoinWaitlist(l.ctx, l.max.ID, l.dune.ID); err != ErrAlreadyWaiting {
+		t.Errorf("joining twice: got %v, want ErrAlreadyWaiting", err)
+	}
+	if _, err := l.Holds.JoinWaitlist(l.ctx, l.max.ID, 99); err != ErrInvalidReference {
+		t.Errorf("missing title: got %v, want ErrInvalidReference", err)
+	}
+
+	// the only copy is out, so there is nothing to promote yet
+	if promoted, err := l.Holds.PromoteHolds(l.ctx, 2*day); err != nil || len(promoted) != 0 {
+		t.Fatalf("promoting with the copy out: got %+v, %v", promoted, err)
+	}
+	hold, err := l.Holds.Hold(l.ctx, id)
+	l.must(err)
+	if hold.Status != HoldWaiting || hold.Position != 1 || hold.BookName != "Dune" {
+		t.Errorf("got hold %+v, want Max first in the queue for Dune", hold)
+	}
+
+	l.must(l.Bookings.Transition(l.ctx, loan, BookingReturned, l.ann.ID))
+	promoted, err := l.Holds.PromoteHolds(l.ctx, 2*day)
+	l.must(err)
+	if len(promoted) != 1 || promoted[0].ID != id || promoted[0].Status != HoldReady || promoted[0].CopyID == nil {
+		t.Fatalf("got promoted %+v, want Max's hold ready with the copy", promoted)
+	}
+	if expires := promoted[0].ExpiresAt; expires == nil || expires.Sub(WallClock()) < 47*time.Hour {
+		t.Errorf("got expiry %v, want two days from now", expires)
+	}
+
+	// the copy is kept for Max: Ann cannot book it, and Max's booking
+	// fulfils the hold
+	if _, err := l.Bookings.Reserve(l.ctx, l.ann.ID, l.dune.ID, WallClock(), WallClock().Add(day), 0); err != ErrConflict {
+		t.Errorf("booking a copy kept for someone else: got %v, want ErrConflict", err)
+	}
+	_, err = l.Bookings.Reserve(l.ctx, l.max.ID, l.dune.ID, WallClock(), WallClock().Add(day), 0)
+	l.must(err)
+	if hold, _ := l.Holds.Hold(l.ctx, id); hold.Status != HoldFulfilled {
+		t.Errorf("got hold status %s after booking, want fulfilled", hold.Status)
+	}
+}
+
+func TestReadyHoldExpires(t *testing.T) {
+	l := newTestLibrary(t)
+	id, err := l.Holds.JoinWaitlist(l.ctx, l.max.ID, l.dune.ID)
+	l.must(err)
+	next, err := l.Holds.JoinWaitlist(l.ctx, l.ann.ID, l.dune.ID)
+	l.must(err)
+
+	// a period that is already over makes the next run expire the hold and
+	// hand the copy to the next in line
+	promoted, err := l.Holds.PromoteHolds(l.ctx, -time.Minute)
+	l.must(err)
+	if len(promoted) != 1 || promoted[0].ID != id {
+		t.Fatalf("got promoted %+v, want Max's hold", promoted)
+	}
+	promoted, err = l.Holds.PromoteHolds(l.ctx, day)
+	l.must(err)
+	if len(promoted) != 1 || promoted[0].ID != next {
+		t.Fatalf("got promoted %+v, want Ann's hold", promoted)
+	}
+	if hold, _ := l.Holds.Hold(l.ctx, id); hold.Status != HoldExpired || hold.ClosedAt == nil {
+		t.Errorf("got hold %+v, want Max's hold expired", hold)
+	}
+
+	l.must(l.Holds.CancelHold(l.ctx, next, l.ann.ID))
+	if err := l.Holds.CancelHold(l.ctx, next, l.ann.ID); err != ErrNotFound {
+		t.Errorf("cancelling twice: got %v, want ErrNotFound", err)
+	}
+	if holds, _ := l.Holds.UserHolds(l.ctx, l.ann.ID); len(holds) != 0 {
+		t.Errorf("got open holds %+v after cancelling", holds)
+	}
+}
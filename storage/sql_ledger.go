@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const day = 24 * time.Hour
+
+// WallClock returns the current time the way timestamps come back from the
+// database: the local wall clock time, without a zone.
+func WallClock() time.Time {
+	now := time.Now()
+	return time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), now.Second(), now.Nanosecond(), time.UTC)
+}
+
+// AccrueFines charges whole started days. fined_until moves on with every
+// charge, even for loans without a fine, so a loan is only looked at again
+// once another day has started.
+func (s *sqlStore) AccrueFines(ctx context.Context, defaultFine Money) (int, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	loans := []Booking{}
+	const overdue = `SELECT * FROM bookings WHERE status IN ($1, $2)
+		AND COALESCE(fined_until, end_time) < COALESCE(returned_at, localtimestamp) FOR UPDATE`
+	if err := tx.SelectContext(ctx, &loans, overdue, BookingCheckedOut, BookingReturned); err != nil {
+		return 0, err
+	}
+	now := WallClock()
+	charged := 0
+	for _, loan := range loans {
+		until := now
+		if loan.ReturnedAt != nil {
+			until = *loan.ReturnedAt
+		}
+		from := 0
+		if loan.FinedUntil != nil {
+			from = int(loan.FinedUntil.Sub(loan.EndTime) / day)
+		}
+		to := int((until.Sub(loan.EndTime) + day - 1) / day)
+		if to <= from {
+			continue
+		}
+
+		var scope struct {
+			BookName   string `db:"book_name"`
+			CategoryID int    `db:"category_id"`
+			Role       string `db:"role"`
+		}
+		const lookup = `SELECT COALESCE(b.book_name, '') AS book_name, COALESCE(b.category_id, 0) AS category_id,
+			COALESCE(u.role, '') AS role
+			FROM bookings bk
+			LEFT JOIN books b ON b.id = bk.book_id
+			LEFT JOIN users u ON u.id = bk.user_id
+			WHERE bk.id = $1`
+		if err := tx.GetContext(ctx, &scope, lookup, loan.ID); err != nil {
+			return 0, notFound(err)
+		}
+		policies, err := matchingPolicies(ctx, tx.runner, scope.CategoryID, scope.Role)
+		if err != nil {
+			return 0, err
+		}
+		rate := Limits{FinePerDay: defaultFine}.Apply(policies).FinePerDay
+		finedUntil := loan.EndTime.Add(time.Duration(to) * day)
+		if _, err := tx.ExecContext(ctx, `UPDATE bookings SET fined_until = $2 WHERE id = $1`, loan.ID, finedUntil); err != nil {
+			return 0, err
+		}
+		if rate <= 0 {
+			continue
+		}
+		note := fmt.Sprintf("Overdue: %s, day %d", scope.BookName, to)
+		if to-from > 1 {
+			note = fmt.Sprintf("Overdue: %s, days %d to %d", scope.BookName, from+1, to)
+		}
+		bookingID := loan.ID
+		entry := LedgerEntry{UserID: loan.UserID, BookingID: &bookingID, Kind: LedgerCharge, Amount: rate * Money(to-from), Note: note}
+		if err := insertLedgerEntry(ctx, tx.runner, &entry); err != nil {
+			return 0, err
+		}
+		charged++
+	}
+	return charged, tx.Commit()
+}
+
+func (s *sqlStore) Balance(ctx context.Context, userID int) (Money, error) {
+	var balance Money
+	const sum = `SELECT COALESCE(sum(CASE WHEN kind = $2 THEN amount ELSE -amount END), 0) FROM ledger_entries WHERE user_id = $1`
+	err := s.db.GetContext(ctx, &balance, sum, userID, LedgerCharge)
+	return balance, err
+}
+
+func (s *sqlStore) LedgerEntries(ctx context.Context, userID, offset, limit int) ([]LedgerEntry, int, error) {
+	total := 0
+	if err := s.db.GetContext(ctx, &total, `SELECT count(*) FROM ledger_entries WHERE user_id = $1`, userID); err != nil {
+		return nil, 0, err
+	}
+	entries := []LedgerEntry{}
+	err := s.db.SelectContext(ctx, &entries, `SELECT * FROM ledger_entries WHERE user_id = $1 ORDER BY id DESC LIMIT $2 OFFSET $3`,
+		userID, limit, offset)
+	return entries, total, err
+}
+
+func (s *sqlStore) AddLedgerEntry(ctx context.Context, entry *LedgerEntry) error {
+	if err := insertLedgerEntry(ctx, s.db.runner, entry); err != nil {
+		if isForeignKeyViolation(err) {
+			return ErrInvalidReference
+		}
+		return err
+	}
+	return nil
+}
+
+func insertLedgerEntry(ctx context.Context, r runner, entry *LedgerEntry) error {
+	const insertEntry = `INSERT INTO ledger_entries(user_id, booking_id, kind, amount, note, created_by, created_at)
+		VALUES($1, $2, $3, $4, $5, $6, localtimestamp) RETURNING id`
+	return r.GetContext(ctx, &entry.ID, insertEntry, entry.UserID, entry.BookingID, entry.Kind, entry.Amount, entry.Note, entry.CreatedBy)
+}
@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAccrueFines(t *testing.T) {
+	l := newTestLibrary(t)
+	// Dune was due two and a half days ago; SPQR came back a day late
+	end := WallClock().Add(-60 * time.Hour)
+	dune, err := l.Bookings.Reserve(l.ctx, l.max.ID, l.dune.ID, end.Add(-day), end, 0)
+	l.must(err)
+	l.must(l.Bookings.Transition(l.ctx, dune, BookingCheckedOut, l.ann.ID))
+	end = WallClock().Add(-20 * time.Hour)
+	spqr, err := l.Bookings.Reserve(l.ctx, l.max.ID, l.spqr.ID, end.Add(-day), end, 0)
+	l.must(err)
+	l.must(l.Bookings.Transition(l.ctx, spqr, BookingCheckedOut, l.ann.ID))
+	l.must(l.Bookings.Transition(l.ctx, spqr, BookingReturned, l.ann.ID))
+
+	fiftyCents := Money(50)
+	l.must(l.Policies.CreatePolicy(l.ctx, &CirculationPolicy{CategoryID: &l.fiction.ID, Role: RoleMember, FinePerDay: &fiftyCents}))
+
+	if n, err := l.Ledger.AccrueFines(l.ctx, 25); err != nil || n != 2 {
+		t.Fatalf("got %d charged, %v, want both loans", n, err)
+	}
+	// three started days for Dune and one for SPQR, at the policy's rate
+	if balance, _ := l.Ledger.Balance(l.ctx, l.max.ID); balance != 4*50 {
+		t.Errorf("got balance %s, want 2.00", balance)
+	}
+	// the days are charged once
+	if n, err := l.Ledger.AccrueFines(l.ctx, 25); err != nil || n != 0 {
+		t.Errorf("second run: got %d charged, %v, want none", n, err)
+	}
+
+	l.must(l.Ledger.AddLedgerEntry(l.ctx, &LedgerEntry{UserID: l.max.ID, Kind: LedgerPayment, Amount: 120, CreatedBy: &l.ann.ID}))
+	l.must(l.Ledger.AddLedgerEntry(l.ctx, &LedgerEntry{UserID: l.max.ID, Kind: LedgerWaiver, Amount: 30, CreatedBy: &l.ann.ID}))
+	if balance, _ := l.Ledger.Balance(l.ctx, l.max.ID); balance != 50 {
+		t.Errorf("got balance %s after a payment and a waiver, want 0.50", balance)
+	}
+	entries, total, err := l.Ledger.LedgerEntries(l.ctx, l.max.ID, 0, 10)
+	l.must(err)
+	if total != 4 || len(entries) != 4 || entries[0].Kind != LedgerWaiver {
+		t.Errorf("got %d of %d entries, want 4 with the newest first", len(entries), total)
+	}
+	if err := l.Ledger.AddLedgerEntry(l.ctx, &LedgerEntry{UserID: 99, Kind: LedgerCharge, Amount: 1}); err != ErrInvalidReference {
+		t.Errorf("entry for a missing user: got %v, want ErrInvalidReference", err)
+	}
+}
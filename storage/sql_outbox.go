@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// The outbox keeps its times on the app's wall clock (WallClock) rather than
+// the database's localtimestamp, so leases and retries written by the app
+// are compared on the same clock even when the two are in different zones.
+
+func (s *sqlStore) QueueMail(ctx context.Context, to, subject, body string) error {
+	const insertMail = `INSERT INTO outbox(recipient, subject, body, status, next_attempt_at, created_at) VALUES($1, $2, $3, $4, $5, $5)`
+	_, err := s.db.ExecContext(ctx, insertMail, to, subject, body, OutboxPending, WallClock())
+	return err
+}
+
+func (s *sqlStore) OutboxMails(ctx context.Context, status string, limit int) ([]OutboxMail, error) {
+	mails := []OutboxMail{}
+	var err error
+	if status == "" {
+		err = s.db.SelectContext(ctx, &mails, `SELECT * FROM outbox ORDER BY id DESC LIMIT $1`, limit)
+	} else {
+		err = s.db.SelectContext(ctx, &mails, `SELECT * FROM outbox WHERE status = $1 ORDER BY id DESC LIMIT $2`, status, limit)
+	}
+	return mails, err
+}
+
+func (s *sqlStore) RetryMail(ctx context.Context, id int) error {
+	const retry = `UPDATE outbox SET status = $2, attempts = 0, next_attempt_at = $4 WHERE id = $1 AND status <> $3`
+	return rowsAffected(s.db.ExecContext(ctx, retry, id, OutboxPending, OutboxSent, WallClock()))
+}
+
+// ClaimMails leases the due mails in a short transaction. SKIP LOCKED lets
+// other instances claim different mails at the same time.
+func (s *sqlStore) ClaimMails(ctx context.Context, limit int, lease time.Duration) ([]OutboxMail, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := WallClock()
+	mails := []OutboxMail{}
+	const due = `SELECT * FROM outbox WHERE status = $1 AND next_attempt_at <= $3
+		ORDER BY id LIMIT $2 FOR UPDATE SKIP LOCKED`
+	if err := tx.SelectContext(ctx, &mails, due, OutboxPending, limit, now); err != nil {
+		return nil, err
+	}
+	leasedUntil := now.Add(lease)
+	for _, mail := range mails {
+		if _, err := tx.ExecContext(ctx, `UPDATE outbox SET next_attempt_at = $2 WHERE id = $1`, mail.ID, leasedUntil); err != nil {
+			return nil, err
+		}
+	}
+	return mails, tx.Commit()
+}
+
+// MarkMailSent also clears the body, which may hold a reset or verification
+// link that must not stay readable in the outbox.
+func (s *sqlStore) MarkMailSent(ctx context.Context, id int) error {
+	const sent = `UPDATE outbox SET status = $2, attempts = attempts + 1, sent_at = $3, body = '' WHERE id = $1`
+	return rowsAffected(s.db.ExecContext(ctx, sent, id, OutboxSent, WallClock()))
+}
+
+func (s *sqlStore) MarkMailFailed(ctx context.Context, id int, lastError string, retryAt *time.Time) error {
+	status := OutboxPending
+	if retryAt == nil {
+		status = OutboxFailed
+	}
+	const failed = `UPDATE outbox SET status = $2, attempts = attempts + 1, last_error = $3, next_attempt_at = $4 WHERE id = $1`
+	return rowsAffected(s.db.ExecContext(ctx, failed, id, status, lastError, retryAt))
+}
+
+func (s *sqlStore) PurgeMails(ctx context.Context, before time.Time) (int, error) {
+	const purge = `DELETE FROM outbox WHERE status IN ($1, $2) AND COALESCE(sent_at, created_at) < $3`
+	res, err := s.db.ExecContext(ctx, purge, OutboxSent, OutboxFailed, before)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
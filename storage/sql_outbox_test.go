@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOutbox(t *testing.T) {
+	l := newTestLibrary(t)
+	l.must(l.Outbox.QueueMail(l.ctx, "ann@example.com", "Welcome", "<p>Hi Ann</p>"))
+	l.must(l.Outbox.QueueMail(l.ctx, "max@example.com", "Welcome", "<p>Hi Max</p>"))
+
+	claimed, err := l.Outbox.ClaimMails(l.ctx, 10, time.Minute)
+	l.must(err)
+	if len(claimed) != 2 {
+		t.Fatalf("got %d claimed, want both mails", len(claimed))
+	}
+	// leased mails are not handed out again
+	if again, _ := l.Outbox.ClaimMails(l.ctx, 10, time.Minute); len(again) != 0 {
+		t.Errorf("got %d claimed while leased, want none", len(again))
+	}
+
+	l.must(l.Outbox.MarkMailSent(l.ctx, claimed[0].ID))
+	retryAt := WallClock().Add(-time.Second)
+	l.must(l.Outbox.MarkMailFailed(l.ctx, claimed[1].ID, "timeout", &retryAt))
+	sent, err := l.Outbox.OutboxMails(l.ctx, OutboxSent, 10)
+	l.must(err)
+	if len(sent) != 1 || sent[0].Body != "" || sent[0].SentAt == nil {
+		t.Errorf("got sent %+v, want one with its body cleared", sent)
+	}
+	retried, err := l.Outbox.ClaimMails(l.ctx, 10, time.Minute)
+	l.must(err)
+	if len(retried) != 1 || retried[0].Attempts != 1 || retried[0].LastError == nil {
+		t.Fatalf("got %+v, want the failed mail due again", retried)
+	}
+	l.must(l.Outbox.MarkMailFailed(l.ctx, retried[0].ID, "timeout", nil))
+
+	if n, err := l.Outbox.PurgeMails(l.ctx, WallClock().Add(-time.Hour)); err != nil || n != 0 {
+		t.Errorf("purging older mails: got %d, %v, want none", n, err)
+	}
+	l.must(l.Outbox.QueueMail(l.ctx, "ann@example.com", "Pending", "body"))
+	if n, err := l.Outbox.PurgeMails(l.ctx, WallClock().Add(time.Hour)); err != nil || n != 2 {
+		t.Errorf("purging: got %d, %v, want the sent and the failed mail", n, err)
+	}
+	if pending, _ := l.Outbox.OutboxMails(l.ctx, OutboxPending, 10); len(pending) != 1 {
+		t.Errorf("got %d pending after purging, want the pending mail kept", len(pending))
+	}
+}
@@ -0,0 +1,60 @@
+package storage
+
+import "context"
+
+const selectPolicy = `SELECT p.*, COALESCE(c.name, '') AS category_name
+	FROM circulation_policies p
+	LEFT JOIN categories c ON c.id = p.category_id`
+
+func (s *sqlStore) Policies(ctx context.Context) ([]CirculationPolicy, error) {
+	policies := []CirculationPolicy{}
+	err := s.db.SelectContext(ctx, &policies, selectPolicy+` ORDER BY p.category_id IS NOT NULL, c.name, p.role`)
+	return policies, err
+}
+
+func (s *sqlStore) Policy(ctx context.Context, id int) (CirculationPolicy, error) {
+	var policy CirculationPolicy
+	err := s.db.GetContext(ctx, &policy, selectPolicy+` WHERE p.id = $1`, id)
+	return policy, notFound(err)
+}
+
+func (s *sqlStore) MatchingPolicies(ctx context.Context, categoryID int, role string) ([]CirculationPolicy, error) {
+	return matchingPolicies(ctx, s.db.runner, categoryID, role)
+}
+
+func matchingPolicies(ctx context.Context, r runner, categoryID int, role string) ([]CirculationPolicy, error) {
+	policies := []CirculationPolicy{}
+	err := r.SelectContext(ctx, &policies, selectPolicy+`
+		WHERE (p.category_id = $1 OR p.category_id IS NULL) AND (p.role = $2 OR p.role = '')
+		ORDER BY p.category_id IS NULL, p.role = ''`, categoryID, role)
+	return policies, err
+}
+
+func (s *sqlStore) CreatePolicy(ctx context.Context, policy *CirculationPolicy) error {
+	const insertPolicy = `INSERT INTO circulation_policies(category_id, role, max_loan_days, max_items_out, max_renewals, fine_per_day)
+		VALUES($1, $2, $3, $4, $5, $6) RETURNING id`
+	err := s.db.GetContext(ctx, &policy.ID, insertPolicy, policy.CategoryID, policy.Role,
+		policy.MaxLoanDays, policy.MaxItemsOut, policy.MaxRenewals, policy.FinePerDay)
+	return policyError(err)
+}
+
+func (s *sqlStore) UpdatePolicy(ctx context.Context, policy CirculationPolicy) error {
+	const updatePolicy = `UPDATE circulation_policies SET category_id = $2, role = $3,
+		max_loan_days = $4, max_items_out = $5, max_renewals = $6, fine_per_day = $7 WHERE id = $1`
+	return policyError(rowsAffected(s.db.ExecContext(ctx, updatePolicy, policy.ID, policy.CategoryID, policy.Role,
+		policy.MaxLoanDays, policy.MaxItemsOut, policy.MaxRenewals, policy.FinePerDay)))
+}
+
+func (s *sqlStore) DeletePolicy(ctx context.Context, id int) error {
+	return rowsAffected(s.db.ExecContext(ctx, `DELETE FROM circulation_policies WHERE id = $1`, id))
+}
+
+func policyError(err error) error {
+	if isUniqueViolation(err) {
+		return ErrPolicyExists
+	}
+	if isForeignKeyViolation(err) {
+		return ErrInvalidReference
+	}
+	return err
+}
@@ -0,0 +1,214 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"library/migrate"
+)
+
+// newTestStores returns the stores on a fresh in-memory SQLite database
+// with every migration applied.
+func newTestStores(t *testing.T) Stores {
+	t.Helper()
+	db, stores, err := Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	m, err := migrate.New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(context.Background()); err != nil {
+		t.Fatalf("migrating: %v", err)
+	}
+	return stores
+}
+
+// testLibrary is a small library: Dune has one copy and SPQR two. Ann signs
+// up first and so is an admin; Max is a member.
+type testLibrary struct {
+	Stores
+	fiction    Category
+	dune, spqr Book
+	ann, max   User
+	ctx        context.Context
+	t          *testing.T
+}
+
+func newTestLibrary(t *testing.T) *testLibrary {
+	t.Helper()
+	l := &testLibrary{Stores: newTestStores(t), ctx: context.Background(), t: t}
+	l.fiction = Category{Name: "Fiction", Status: true}
+	l.must(l.Categories.CreateCategory(l.ctx, &l.fiction))
+	l.dune = Book{Category_id: l.fiction.ID, Book_name: "Dune", AuthorName: "Frank Herbert", Details: "Spice and sand worms", Status: true}
+	l.must(l.Books.CreateBook(l.ctx, &l.dune))
+	l.spqr = Book{Category_id: l.fiction.ID, Book_name: "SPQR", AuthorName: "Mary Beard", Details: "A history of ancient Rome", Status: true, Copies: 2}
+	l.must(l.Books.CreateBook(l.ctx, &l.spqr))
+	l.ann = User{FirstName: "Ann", Email: "ann@example.com", Password: "hash"}
+	l.must(l.Users.CreateUser(l.ctx, &l.ann))
+	l.max = User{FirstName: "Max", Email: "max@example.com", Password: "hash"}
+	l.must(l.Users.CreateUser(l.ctx, &l.max))
+	return l
+}
+
+func (l *testLibrary) must(err error) {
+	l.t.Helper()
+	if err != nil {
+		l.t.Fatal(err)
+	}
+}
+
+// window returns a booking window starting days days from now at 10:00 on
+// the wall clock.
+func window(days int, length time.Duration) (time.Time, time.Time) {
+	start := WallClock().Truncate(day).AddDate(0, 0, days).Add(10 * time.Hour)
+	return start, start.Add(length)
+}
+
+func TestCategoriesAndBooks(t *testing.T) {
+	l := newTestLibrary(t)
+
+	book, err := l.Books.Book(l.ctx, l.spqr.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.Book_name != "SPQR" || book.Cat_name != "Fiction" || book.TotalCopies != 2 || book.AvailableCopies != 2 {
+		t.Errorf("got book %+v, want SPQR in Fiction with 2 of 2 copies free", book)
+	}
+	book.AuthorName = "M. Beard"
+	l.must(l.Books.UpdateBook(l.ctx, book))
+	if book, _ := l.Books.Book(l.ctx, l.spqr.ID); book.AuthorName != "M. Beard" {
+		t.Errorf("got author %q after the update", book.AuthorName)
+	}
+	if err := l.Books.UpdateBook(l.ctx, Book{ID: 99, Category_id: l.fiction.ID}); err != ErrNotFound {
+		t.Errorf("updating a missing book: got %v, want ErrNotFound", err)
+	}
+	if err := l.Books.CreateBook(l.ctx, &Book{Category_id: 99, Book_name: "Nowhere"}); err != ErrInvalidReference {
+		t.Errorf("book in a missing category: got %v, want ErrInvalidReference", err)
+	}
+
+	copies, err := l.Books.Copies(l.ctx, l.spqr.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(copies) != 2 || copies[0].Barcode == copies[1].Barcode {
+		t.Fatalf("got copies %+v, want two with their own barcodes", copies)
+	}
+	extra := BookCopy{BookID: l.spqr.ID, Barcode: "EXTRA-1", Condition: "good", ShelfLocation: "A1", Status: true}
+	l.must(l.Books.CreateCopy(l.ctx, &extra))
+	if taken, _ := l.Books.BarcodeTaken(l.ctx, "EXTRA-1", 0); !taken {
+		t.Error("barcode of the new copy is not taken")
+	}
+	if taken, _ := l.Books.BarcodeTaken(l.ctx, "EXTRA-1", extra.ID); taken {
+		t.Error("barcode is taken by the copy itself")
+	}
+	l.must(l.Books.DeleteCopy(l.ctx, extra.ID))
+	if _, err := l.Books.Copy(l.ctx, extra.ID); err != ErrNotFound {
+		t.Errorf("deleted copy: got %v, want ErrNotFound", err)
+	}
+
+	if err := l.Categories.DeleteCategory(l.ctx, l.fiction.ID, 0); err != ErrInUse {
+		t.Errorf("deleting a category with books: got %v, want ErrInUse", err)
+	}
+	history := Category{Name: "History", Status: true}
+	l.must(l.Categories.CreateCategory(l.ctx, &history))
+	l.must(l.Categories.DeleteCategory(l.ctx, l.fiction.ID, history.ID))
+	if n, _ := l.Categories.CountBooks(l.ctx, history.ID); n != 2 {
+		t.Errorf("got %d books in History, want both moved there", n)
+	}
+}
+
+func TestDeleteBookWithActiveBooking(t *testing.T) {
+	l := newTestLibrary(t)
+	start, end := window(1, day)
+	id, err := l.Bookings.Reserve(l.ctx, l.max.ID, l.dune.ID, start, end, 0)
+	l.must(err)
+
+	if err := l.Books.DeleteBook(l.ctx, l.dune.ID); err != ErrActiveBookings {
+		t.Fatalf("deleting a booked title: got %v, want ErrActiveBookings", err)
+	}
+	l.must(l.Bookings.Transition(l.ctx, id, BookingCancelled, l.ann.ID))
+	l.must(l.Books.DeleteBook(l.ctx, l.dune.ID))
+	if _, err := l.Bookings.Booking(l.ctx, id); err != ErrNotFound {
+		t.Errorf("booking of the deleted title: got %v, want it removed", err)
+	}
+}
+
+func TestUsers(t *testing.T) {
+	l := newTestLibrary(t)
+	if l.ann.Role != RoleAdmin || l.max.Role != RoleMember {
+		t.Errorf("got roles %s and %s, want the first user admin and the next a member", l.ann.Role, l.max.Role)
+	}
+	user, err := l.Users.UserByEmail(l.ctx, "Max@Example.com")
+	if err != nil || user.ID != l.max.ID {
+		t.Errorf("by email: got user %d, %v, want Max", user.ID, err)
+	}
+	if err := l.Users.CreateUser(l.ctx, &User{FirstName: "Maxi", Email: "MAX@example.com"}); err != ErrEmailTaken {
+		t.Errorf("signing up with Max's email: got %v, want ErrEmailTaken", err)
+	}
+	if err := l.Users.SetRole(l.ctx, l.ann.ID, RoleMember); err != ErrLastAdmin {
+		t.Errorf("demoting the last admin: got %v, want ErrLastAdmin", err)
+	}
+	l.must(l.Users.SetRole(l.ctx, l.max.ID, RoleAdmin))
+	l.must(l.Users.SetRole(l.ctx, l.ann.ID, RoleMember))
+
+	token := APIToken{UserID: l.max.ID, Name: "laptop", TokenHash: "h1", Hint: "abcd"}
+	l.must(l.Users.CreateAPIToken(l.ctx, &token))
+	if _, err := l.Users.APITokenByHash(l.ctx, "h1"); err != nil {
+		t.Errorf("looking up the token: %v", err)
+	}
+	reset := PasswordReset{UserID: l.max.ID, TokenHash: "r1", ExpiresAt: WallClock().Add(time.Hour)}
+	l.must(l.Users.CreatePasswordReset(l.ctx, &reset))
+	l.must(l.Users.ResetPassword(l.ctx, reset.ID, "new hash"))
+	if _, err := l.Users.APITokenByHash(l.ctx, "h1"); err != ErrNotFound {
+		t.Errorf("token after a password reset: got %v, want it revoked", err)
+	}
+	if err := l.Users.ResetPassword(l.ctx, reset.ID, "other hash"); err != ErrNotFound {
+		t.Errorf("reusing the reset: got %v, want ErrNotFound", err)
+	}
+	max, _ := l.Users.User(l.ctx, l.max.ID)
+	if max.Password != "new hash" || max.SessionVersion != 1 {
+		t.Errorf("got password %q and session version %d after the reset", max.Password, max.SessionVersion)
+	}
+}
+
+func TestUniqueEmailMigration(t *testing.T) {
+	ctx := context.Background()
+	db, stores, err := Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	m, err := migrate.New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// accounts from before the index may share an email
+	if _, err := m.Down(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	users := []User{{Email: "ann@example.com"}, {Email: "Ann@Example.com"}, {Email: "max@example.com"}}
+	for i := range users {
+		if err := stores.Users.CreateUser(ctx, &users[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatalf("migrating with duplicates: %v", err)
+	}
+
+	want := []string{"ann@example.com", fmt.Sprintf("duplicate-%d+Ann@Example.com", users[1].ID), "max@example.com"}
+	for i, user := range users {
+		got, err := stores.Users.User(ctx, user.ID)
+		if err != nil || got.Email != want[i] {
+			t.Errorf("user %d: got email %q, %v, want %q", user.ID, got.Email, err, want[i])
+		}
+	}
+}
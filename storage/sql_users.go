@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+func (s *sqlStore) Users(ctx context.Context) ([]User, error) {
+	users := []User{}
+	err := s.db.SelectContext(ctx, &users, `SELECT * FROM users ORDER BY id`)
+	return users, err
+}
+
+func (s *sqlStore) PageUsers(ctx context.Context, offset, limit int) ([]User, int, error) {
+	total := 0
+	if err := s.db.GetContext(ctx, &total, `SELECT count(*) FROM users`); err != nil {
+		return nil, 0, err
+	}
+	users := []User{}
+	err := s.db.SelectContext(ctx, &users, `SELECT * FROM users ORDER BY id LIMIT $1 OFFSET $2`, limit, offset)
+	return users, total, err
+}
+
+func (s *sqlStore) User(ctx context.Context, id int) (User, error) {
+	var user User
+	err := s.db.GetContext(ctx, &user, `SELECT * FROM users WHERE id = $1`, id)
+	return user, notFound(err)
+}
+
+func (s *sqlStore) UserByEmail(ctx context.Context, email string) (User, error) {
+	var user User
+	err := s.db.GetContext(ctx, &user, `SELECT * FROM users WHERE lower(email) = lower($1)`, email)
+	return user, notFound(err)
+}
+
+func (s *sqlStore) CreateUser(ctx context.Context, user *User) error {
+	const insertUser = `INSERT INTO users(first_name, last_name, email, password, role, is_verified)
+		VALUES($1, $2, $3, $4, CASE WHEN EXISTS (SELECT 1 FROM users) THEN $5 ELSE $6 END, false)
+		RETURNING id, role`
+	row := s.db.QueryRowxContext(ctx, insertUser, user.FirstName, user.LastName, user.Email, user.Password, RoleMember, RoleAdmin)
+	user.IsVerified = false
+	if err := row.Scan(&user.ID, &user.Role); err != nil {
+		if isUniqueViolation(err) {
+			return ErrEmailTaken
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *sqlStore) MarkVerified(ctx context.Context, id int) error {
+	return rowsAffected(s.db.ExecContext(ctx, `UPDATE users SET is_verified = true WHERE id = $1`, id))
+}
+
+// SetRole locks every admin row before counting them, so two admins cannot
+// demote each other at the same time and leave none behind.
+func (s *sqlStore) SetRole(ctx context.Context, id int, role string) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var current string
+	if err := tx.GetContext(ctx, &current, `SELECT role FROM users WHERE id = $1 FOR UPDATE`, id); err != nil {
+		return notFound(err)
+	}
+	if current == RoleAdmin && role != RoleAdmin {
+		admins := []int{}
+		if err := tx.SelectContext(ctx, &admins, `SELECT id FROM users WHERE role = $1 FOR UPDATE`, RoleAdmin); err != nil {
+			return err
+		}
+		if len(admins) <= 1 {
+			return ErrLastAdmin
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET role = $2 WHERE id = $1`, id, role); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) APITokens(ctx context.Context, userID int) ([]APIToken, error) {
+	tokens := []APIToken{}
+	err := s.db.SelectContext(ctx, &tokens, `SELECT * FROM api_tokens WHERE user_id = $1 ORDER BY id DESC`, userID)
+	return tokens, err
+}
+
+func (s *sqlStore) APITokenByHash(ctx context.Context, hash string) (APIToken, error) {
+	var token APIToken
+	err := s.db.GetContext(ctx, &token, `SELECT * FROM api_tokens WHERE token_hash = $1 AND revoked_at IS NULL`, hash)
+	if err != nil {
+		return token, notFound(err)
+	}
+	_, err = s.db.ExecContext(ctx, `UPDATE api_tokens SET last_used_at = localtimestamp WHERE id = $1`, token.ID)
+	return token, err
+}
+
+func (s *sqlStore) CreateAPIToken(ctx context.Context, token *APIToken) error {
+	const insertToken = `INSERT INTO api_tokens(user_id, name, token_hash, hint, created_at) VALUES($1, $2, $3, $4, localtimestamp) RETURNING id, created_at`
+	return s.db.QueryRowxContext(ctx, insertToken, token.UserID, token.Name, token.TokenHash, token.Hint).Scan(&token.ID, &token.CreatedAt)
+}
+
+func (s *sqlStore) RevokeAPIToken(ctx context.Context, id, userID int) error {
+	const revoke = `UPDATE api_tokens SET revoked_at = localtimestamp WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`
+	return rowsAffected(s.db.ExecContext(ctx, revoke, id, userID))
+}
+
+func (s *sqlStore) CreatePasswordReset(ctx context.Context, reset *PasswordReset) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `UPDATE password_resets SET used_at = localtimestamp WHERE user_id = $1 AND used_at IS NULL`, reset.UserID); err != nil {
+		return err
+	}
+	const insertReset = `INSERT INTO password_resets(user_id, token_hash, expires_at, created_at) VALUES($1, $2, $3, localtimestamp) RETURNING id, created_at`
+	if err := tx.QueryRowxContext(ctx, insertReset, reset.UserID, reset.TokenHash, reset.ExpiresAt).Scan(&reset.ID, &reset.CreatedAt); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) PasswordReset(ctx context.Context, hash string) (PasswordReset, error) {
+	var reset PasswordReset
+	err := s.db.GetContext(ctx, &reset, `SELECT * FROM password_resets WHERE token_hash = $1 AND used_at IS NULL AND expires_at > $2`, hash, time.Now())
+	return reset, notFound(err)
+}
+
+// ResetPassword marks the token used inside the transaction, which makes it
+// single-use even when the form is submitted twice at the same time.
+// Bumping session_version logs out every existing session of the user, and
+// revoking their API tokens shuts out scripts using a leaked token.
+func (s *sqlStore) ResetPassword(ctx context.Context, resetID int, passwordHash string) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var userID int
+	if err := tx.GetContext(ctx, &userID, `UPDATE password_resets SET used_at = localtimestamp WHERE id = $1 AND used_at IS NULL RETURNING user_id`, resetID); err != nil {
+		return notFound(err)
+	}
+	const updatePassword = `UPDATE users SET password = $2, session_version = session_version + 1 WHERE id = $1`
+	if _, err := tx.ExecContext(ctx, updatePassword, userID, passwordHash); err != nil {
+		return err
+	}
+	const revokeTokens = `UPDATE api_tokens SET revoked_at = localtimestamp WHERE user_id = $1 AND revoked_at IS NULL`
+	if _, err := tx.ExecContext(ctx, revokeTokens, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
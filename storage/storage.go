@@ -0,0 +1,260 @@
+// Package storage keeps the SQL of the library behind small interfaces so
+// the HTTP handlers do not talk to the database directly. One SQL
+// implementation runs on Postgres or SQLite; tests can provide fakes of the
+// interfaces they need.
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when the requested row does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned when every copy of a title is already booked
+	// for the requested window.
+	ErrConflict = errors.New("every copy of this book is already booked for the requested time")
+	// ErrInvalidTransition is returned when a booking cannot move to the
+	// requested status.
+	ErrInvalidTransition = errors.New("the booking cannot move to that status")
+	// ErrActiveBookings is returned when deleting a book or copy that still
+	// has reserved or checked out bookings.
+	ErrActiveBookings = errors.New("it still has active bookings")
+	// ErrInUse is returned when deleting a row that others still refer to,
+	// such as a category that has books.
+	ErrInUse = errors.New("it is still in use")
+	// ErrInvalidReference is returned when a write refers to a row that does
+	// not exist, such as a book in an unknown category.
+	ErrInvalidReference = errors.New("it refers to a missing record")
+	// ErrLastAdmin is returned when the only admin would lose the role.
+	ErrLastAdmin = errors.New("the last admin cannot be demoted")
+	// ErrNotRenewable is returned when renewing a booking that has ended or
+	// is overdue.
+	ErrNotRenewable = errors.New("only active bookings that are not overdue can be renewed")
+	// ErrRenewalLimit is returned when a booking was renewed as often as
+	// the policy allows.
+	ErrRenewalLimit = errors.New("this booking cannot be renewed again")
+	// ErrItemsOutLimit is returned when reserving a book while the user has
+	// as many books reserved and checked out as the policy allows.
+	ErrItemsOutLimit = errors.New("too many books reserved and checked out")
+	// ErrHoldsWaiting is returned when renewing a booking of a title other
+	// members hold, whether they still wait or have a copy kept for them.
+	ErrHoldsWaiting = errors.New("other members are waiting for this book")
+	// ErrPolicyExists is returned when a circulation policy for the same
+	// category and role already exists.
+	ErrPolicyExists = errors.New("a policy for this category and role already exists")
+	// ErrEmailTaken is returned when creating a user with an email that
+	// another account has, in any case.
+	ErrEmailTaken = errors.New("an account with this email already exists")
+	// ErrAlreadyWaiting is returned when a member joins the waitlist of a
+	// title they are already waiting for or holding.
+	ErrAlreadyWaiting = errors.New("you are already on the waitlist for this book")
+)
+
+// Stores bundles every store the application needs.
+type Stores struct {
+	Books      BookStore
+	Categories CategoryStore
+	Bookings   BookingStore
+	Users      UserStore
+	Outbox     OutboxStore
+	Holds      HoldStore
+	Ledger     LedgerStore
+	Policies   PolicyStore
+}
+
+type CategoryStore interface {
+	// Categories returns every category ordered by name.
+	Categories(ctx context.Context) ([]Category, error)
+	// PageCategories returns one page of categories and the total count.
+	PageCategories(ctx context.Context, offset, limit int) ([]Category, int, error)
+	SearchCategories(ctx context.Context, query string) ([]Category, error)
+	Category(ctx context.Context, id int) (Category, error)
+	CreateCategory(ctx context.Context, category *Category) error
+	UpdateCategory(ctx context.Context, category Category) error
+	// CountBooks returns how many books are in the category.
+	CountBooks(ctx context.Context, categoryID int) (int, error)
+	// DeleteCategory moves the category's books to reassignTo, when it is
+	// not zero, and deletes the category. It returns ErrInUse if books
+	// would be left behind.
+	DeleteCategory(ctx context.Context, id, reassignTo int) error
+}
+
+// BookStore manages titles and their physical copies. Books returned by it
+// carry the category name and copy counts.
+type BookStore interface {
+	// Books returns every book ordered by name.
+	Books(ctx context.Context) ([]Book, error)
+	PageBooks(ctx context.Context, offset, limit int) ([]Book, int, error)
+	// SearchBooks returns one page of the books matching search and the
+	// total count.
+	SearchBooks(ctx context.Context, search BookSearch, offset, limit int) ([]Book, int, error)
+	Book(ctx context.Context, id int) (Book, error)
+	// CreateBook stores the book with book.Copies generated copies, at
+	// least one, so it can be booked straight away.
+	CreateBook(ctx context.Context, book *Book) error
+	UpdateBook(ctx context.Context, book Book) error
+	// DeleteBook removes the book with its copies and finished bookings.
+	// It returns ErrActiveBookings while any booking is still active.
+	DeleteBook(ctx context.Context, id int) error
+
+	Copies(ctx context.Context, bookID int) ([]BookCopy, error)
+	Copy(ctx context.Context, id int) (BookCopy, error)
+	CreateCopy(ctx context.Context, bookCopy *BookCopy) error
+	UpdateCopy(ctx context.Context, bookCopy BookCopy) error
+	DeleteCopy(ctx context.Context, id int) error
+	// BarcodeTaken reports whether a copy other than exceptID uses barcode.
+	BarcodeTaken(ctx context.Context, barcode string, exceptID int) (bool, error)
+}
+
+// BookingStore manages bookings. Bookings returned by it carry the book
+// name, copy barcode and user email.
+type BookingStore interface {
+	// Reserve books a free copy of the title for the window and returns the
+	// new booking ID, or ErrConflict when no copy is free. Copies kept for
+	// other members by a hold are not free. When maxItemsOut is above zero
+	// it returns ErrItemsOutLimit once the user has that many reserved and
+	// checked out bookings.
+	Reserve(ctx context.Context, userID, bookID int, start, end time.Time, maxItemsOut int) (int, error)
+	// NextFreeSlot finds the earliest time at or after from when some copy
+	// of the title is free for length. It reports false when the title has
+	// no copies in circulation.
+	NextFreeSlot(ctx context.Context, bookID int, from time.Time, length time.Duration) (time.Time, bool, error)
+	// Schedule returns the reserved and checked out bookings of the title
+	// that overlap from to to, by copy and start time. Overdue loans are
+	// included until they are checked in.
+	Schedule(ctx context.Context, bookID int, from, to time.Time) ([]Booking, error)
+	Booking(ctx context.Context, id int) (Booking, error)
+	// ListBookings returns one page of the bookings matching filter, newest
+	// first, and the total count.
+	ListBookings(ctx context.Context, filter BookingFilter, offset, limit int) ([]Booking, int, error)
+	// BookingsAfter returns up to limit bookings matching filter that come
+	// after the cursor in the order of ListBookings, or from the start when
+	// after is nil. Unlike ListBookings its cost does not grow with depth.
+	BookingsAfter(ctx context.Context, filter BookingFilter, after *BookingCursor, limit int) ([]Booking, error)
+	// Transition moves a booking to a new status and records who made the
+	// change.
+	Transition(ctx context.Context, id int, to string, performedBy int) error
+	// ExpireReservations marks reservations whose window ended without the
+	// copy being checked out as no-shows and returns how many there were.
+	ExpireReservations(ctx context.Context) (int, error)
+	// Renew moves the end of an active booking on by period and records the
+	// renewal. It returns ErrNotRenewable for bookings that ended or are
+	// overdue, ErrRenewalLimit once the booking was renewed maxRenewals
+	// times, ErrHoldsWaiting while the title has waiting or ready holds and
+	// ErrConflict when the copy is booked by someone else in the extra time.
+	Renew(ctx context.Context, id int, period time.Duration, maxRenewals, performedBy int) error
+}
+
+// HoldStore manages the waitlists. Members queue for a title and, in the
+// order they joined, get a copy kept for them for a while once one is free.
+// Holds returned by it carry the book name and the member's email and first
+// name.
+type HoldStore interface {
+	// JoinWaitlist puts the user at the end of the title's queue and returns
+	// the new hold ID, or ErrAlreadyWaiting.
+	JoinWaitlist(ctx context.Context, userID, bookID int) (int, error)
+	Hold(ctx context.Context, id int) (Hold, error)
+	// UserHolds returns the user's waiting and ready holds, oldest first.
+	UserHolds(ctx context.Context, userID int) ([]Hold, error)
+	// CancelHold closes one of the user's open holds. A ready hold's copy
+	// goes to the next member at the following PromoteHolds.
+	CancelHold(ctx context.Context, id, userID int) error
+	// PromoteHolds expires ready holds that were not booked in time, then
+	// keeps each free copy for the first waiting member of its title until
+	// now plus period. It returns the holds that became ready.
+	PromoteHolds(ctx context.Context, period time.Duration) ([]Hold, error)
+}
+
+// LedgerStore keeps the members' accounts: the overdue fines charged to
+// them and the payments and waivers set against those.
+type LedgerStore interface {
+	// AccrueFines charges every loan for the overdue days it was not
+	// charged for yet and returns how many loans were charged. A loan is
+	// overdue from its end time until it is checked in. The fine per day
+	// comes from the circulation policies for the book's category and the
+	// member's role, or is defaultFine when none sets it.
+	AccrueFines(ctx context.Context, defaultFine Money) (int, error)
+	// Balance returns what the user owes.
+	Balance(ctx context.Context, userID int) (Money, error)
+	// LedgerEntries returns one page of the user's entries, newest first,
+	// and the total count.
+	LedgerEntries(ctx context.Context, userID, offset, limit int) ([]LedgerEntry, int, error)
+	AddLedgerEntry(ctx context.Context, entry *LedgerEntry) error
+}
+
+// PolicyStore manages the circulation policies.
+type PolicyStore interface {
+	// Policies returns every policy, the ones for all categories first.
+	Policies(ctx context.Context) ([]CirculationPolicy, error)
+	Policy(ctx context.Context, id int) (CirculationPolicy, error)
+	// MatchingPolicies returns the policies for lending a book of the
+	// category to a member of the role, most specific first: those for the
+	// category and role, for the category, for the role and for everyone.
+	MatchingPolicies(ctx context.Context, categoryID int, role string) ([]CirculationPolicy, error)
+	// CreatePolicy and UpdatePolicy return ErrPolicyExists when another
+	// policy has the same category and role, and ErrInvalidReference for a
+	// missing category.
+	CreatePolicy(ctx context.Context, policy *CirculationPolicy) error
+	UpdatePolicy(ctx context.Context, policy CirculationPolicy) error
+	DeletePolicy(ctx context.Context, id int) error
+}
+
+// UserStore manages accounts and the credentials attached to them.
+type UserStore interface {
+	// Users returns every user ordered by ID.
+	Users(ctx context.Context) ([]User, error)
+	PageUsers(ctx context.Context, offset, limit int) ([]User, int, error)
+	User(ctx context.Context, id int) (User, error)
+	// UserByEmail finds the user by email, ignoring case.
+	UserByEmail(ctx context.Context, email string) (User, error)
+	// CreateUser stores a new unverified user. The first account becomes an
+	// admin so the library can be set up. It returns ErrEmailTaken when the
+	// email is in use.
+	CreateUser(ctx context.Context, user *User) error
+	MarkVerified(ctx context.Context, id int) error
+	// SetRole changes a user's role. It returns ErrLastAdmin instead of
+	// demoting the only admin.
+	SetRole(ctx context.Context, id int, role string) error
+
+	APITokens(ctx context.Context, userID int) ([]APIToken, error)
+	// APITokenByHash returns the unrevoked token with the given hash and
+	// records that it was used.
+	APITokenByHash(ctx context.Context, hash string) (APIToken, error)
+	CreateAPIToken(ctx context.Context, token *APIToken) error
+	RevokeAPIToken(ctx context.Context, id, userID int) error
+
+	// CreatePasswordReset stores a reset token and invalidates the user's
+	// older ones, so only the latest link works.
+	CreatePasswordReset(ctx context.Context, reset *PasswordReset) error
+	// PasswordReset returns the unused, unexpired reset with the given hash.
+	PasswordReset(ctx context.Context, hash string) (PasswordReset, error)
+	// ResetPassword uses up the reset and sets the new password hash. It
+	// also logs out every session of the user and revokes their API tokens.
+	// It returns ErrNotFound if the reset was used in the meantime.
+	ResetPassword(ctx context.Context, resetID int, passwordHash string) error
+}
+
+// OutboxStore queues mail for the scheduler's mail job.
+type OutboxStore interface {
+	QueueMail(ctx context.Context, to, subject, body string) error
+	// OutboxMails returns the latest mails, optionally only those with the
+	// given status.
+	OutboxMails(ctx context.Context, status string, limit int) ([]OutboxMail, error)
+	// RetryMail puts an unsent mail back at the front of the queue.
+	RetryMail(ctx context.Context, id int) error
+	// ClaimMails returns up to limit due mails and pushes their next attempt
+	// back by lease, so other runs of the mail job leave them alone while
+	// they are being sent.
+	ClaimMails(ctx context.Context, limit int, lease time.Duration) ([]OutboxMail, error)
+	// MarkMailSent records the delivery and clears the body.
+	MarkMailSent(ctx context.Context, id int) error
+	// MarkMailFailed counts a failed attempt. The mail is tried again at
+	// retryAt, a WallClock time, or marked failed when retryAt is nil.
+	MarkMailFailed(ctx context.Context, id int, lastError string, retryAt *time.Time) error
+	// PurgeMails deletes sent and failed mails older than before, a
+	// WallClock time, and returns how many there were.
+	PurgeMails(ctx context.Context, before time.Time) (int, error)
+}